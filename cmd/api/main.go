@@ -3,8 +3,8 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,7 +12,8 @@ import (
 	"time"
 
 	temporaladapter "async-file-storage/internal/adapters/temporal"
-	"async-file-storage/internal/repository"
+	"async-file-storage/internal/app"
+	"async-file-storage/internal/config"
 	httptransport "async-file-storage/internal/transport/http"
 	"async-file-storage/internal/usecase"
 
@@ -22,29 +23,30 @@ import (
 
 func main() {
 	_ = godotenv.Load()
-	// TODO: нужна структура Config и метод для ее загрузки из env, а не куча отдельных переменных
-	dsn := os.Getenv("DB_URL")
-	if dsn == "" {
-		user := os.Getenv("DB_USER")
-		password := os.Getenv("DB_PASSWORD")
-		host := os.Getenv("DB_HOST")
-		if host == "" {
-			host = "localhost"
-		}
-		port := os.Getenv("DB_PORT")
-		if port == "" {
-			port = "5433"
-		}
-		name := os.Getenv("DB_NAME")
-		if name == "" {
-			name = "downloader"
-		}
-		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, password, host, port, name)
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	repo, err := repository.NewPostgresRepository(dsn)
+	repo, err := app.OpenRepository(cfg)
 	if err != nil {
 		log.Fatalf("Failed to init repository: %v", err)
 	}
+	blobs, err := app.OpenBlobStore(context.Background(), cfg, repo)
+	if err != nil {
+		log.Fatalf("Failed to init blob store: %v", err)
+	}
+	keys, err := app.OpenKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+
+	// Canceled on shutdown, which ends the event streams still open.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	hub, err := app.OpenEventHub(baseCtx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to init event hub: %v", err)
+	}
 
 	tc, err := client.Dial(client.Options{})
 	if err != nil {
@@ -52,8 +54,21 @@ func main() {
 	}
 	defer tc.Close()
 
-	downloader := temporaladapter.NewDownloader(tc, "file-storage-tasks")
-	service := usecase.NewService(repo, downloader)
+	downloader := temporaladapter.NewDownloader(tc, cfg.TaskQueue)
+	serviceCfg := usecase.Config{
+		DefaultRetention: cfg.DefaultRetention,
+		MaxRetention:     cfg.MaxRetention,
+
+		RejectDuplicateURLs: cfg.RejectDuplicateURLs,
+
+		Events: hub,
+
+		AllowCallbacks: cfg.WebhookSecret != "",
+	}
+	if keys != nil {
+		serviceCfg.Keys = keys
+	}
+	service := usecase.NewService(repo, downloader, blobs, serviceCfg)
 	handler := httptransport.NewHandler(service)
 
 	var finalHandler http.Handler = handler
@@ -62,16 +77,18 @@ func main() {
 	finalHandler = httptransport.Logging(finalHandler)
 
 	srv := &http.Server{
-		// TODO: port должен быть в конфиге
-		Addr:    ":8080",
+		Addr:    cfg.HTTPAddr,
 		Handler: finalHandler,
+
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
+	srv.RegisterOnShutdown(cancelBase)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		log.Println("API Server started on :8080")
+		log.Printf("API Server started on %s", cfg.HTTPAddr)
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Listen error: %s\n", err)
 		}
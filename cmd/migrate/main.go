@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/migrate"
+	"async-file-storage/internal/repository"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down        revert the most recent migration
+  status      list migrations and whether they are applied
+  to VERSION  migrate up or down to VERSION (0 reverts everything)`
+
+func main() {
+	_ = godotenv.Load()
+	cfg := config.Load()
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var (
+		db       *sql.DB
+		migrator *migrate.Migrator
+		err      error
+	)
+	switch cfg.Storage {
+	case config.StoragePostgres:
+		if db, err = repository.OpenPostgres(cfg.DatabaseURL); err == nil {
+			migrator, err = repository.NewPostgresMigrator(db)
+		}
+	case config.StorageSQLite:
+		if db, err = repository.OpenSQLite(cfg.SQLitePath); err == nil {
+			migrator, err = repository.NewSQLiteMigrator(db)
+		}
+	default:
+		log.Fatalf("Storage %q has no schema to migrate", cfg.Storage)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		version, convErr := strconv.Atoi(os.Args[2])
+		if convErr != nil {
+			log.Fatalf("Invalid version %q", os.Args[2])
+		}
+		err = migrator.To(ctx, version)
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	version, err := migrator.Current(ctx)
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+	log.Printf("Schema version: %d (latest %d)", version, migrator.Latest())
+}
+
+func printStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		applied := "pending"
+		if st.AppliedAt != nil {
+			applied = "applied " + st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d  %-30s %s\n", st.Version, st.Name, applied)
+	}
+	return nil
+}
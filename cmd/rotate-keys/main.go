@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"async-file-storage/internal/app"
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/envelope"
+
+	"github.com/joho/godotenv"
+)
+
+// rotate-keys re-wraps every stored data key with the active master key from
+// ENCRYPTION_KEY_FILE. Stored content is not re-encrypted. Once it succeeds,
+// retired master keys can be removed from the key file.
+func main() {
+	batch := flag.Int("batch", 100, "number of data keys re-wrapped per query")
+	flag.Parse()
+
+	_ = godotenv.Load()
+	cfg := config.Load()
+	keys, err := app.OpenKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	if keys == nil {
+		log.Fatal("ENCRYPTION_KEY_FILE is not set")
+	}
+	repo, err := app.OpenRepository(cfg)
+	if err != nil {
+		log.Fatalf("Failed to init repository: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	n, err := envelope.Rotate(ctx, repo, keys, *batch)
+	if err != nil {
+		log.Fatalf("Rotated %d data keys before failing: %v", n, err)
+	}
+	log.Printf("Rotated %d data keys to master key %q", n, keys.ActiveKeyID())
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"os"
 	"time"
 
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/domain"
 	"async-file-storage/internal/repository"
 	"async-file-storage/internal/temporal"
 
@@ -15,27 +15,9 @@ import (
 )
 
 func main() {
-	// TODO: структура Config
 	_ = godotenv.Load()
-	dsn := os.Getenv("DB_URL")
-	if dsn == "" {
-		user := os.Getenv("DB_USER")
-		password := os.Getenv("DB_PASSWORD")
-		host := os.Getenv("DB_HOST")
-		if host == "" {
-			host = "localhost"
-		}
-		port := os.Getenv("DB_PORT")
-		if port == "" {
-			port = "5433"
-		}
-		name := os.Getenv("DB_NAME")
-		if name == "" {
-			name = "downloader"
-		}
-		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, password, host, port, name)
-	}
-	repo, _ := repository.NewPostgresRepository(dsn)
+	cfg := config.Load()
+	repo, _ := repository.NewPostgresRepository(cfg.DatabaseURL)
 
 	urls := []string{
 		"https://raw.githubusercontent.com/temporalio/samples-go/master/README.md",
@@ -43,7 +25,7 @@ func main() {
 	}
 	timeout := 60 * time.Second
 
-	requestID, err := repo.CreateRequest(context.Background(), urls)
+	requestID, files, err := repo.CreateRequest(context.Background(), domain.NewRequest{URLs: urls})
 	if err != nil {
 		log.Fatalf("Failed to create request in DB: %v", err)
 	}
@@ -57,10 +39,10 @@ func main() {
 	// 4. Запускаем Workflow
 	options := client.StartWorkflowOptions{
 		ID:        "download_request_test",
-		TaskQueue: "file-storage-tasks",
+		TaskQueue: cfg.TaskQueue,
 	}
 
-	we, err := c.ExecuteWorkflow(context.Background(), options, temporal.DownloadWorkflow, requestID, urls, timeout)
+	we, err := c.ExecuteWorkflow(context.Background(), options, temporal.DownloadWorkflow, requestID, files, timeout)
 	if err != nil {
 		log.Fatalln("Unable to execute workflow", err)
 	}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"os"
 
-	"async-file-storage/internal/repository"
+	temporaladapter "async-file-storage/internal/adapters/temporal"
+	"async-file-storage/internal/app"
+	"async-file-storage/internal/config"
 	"async-file-storage/internal/temporal"
+	"async-file-storage/internal/webhook"
 
 	"github.com/joho/godotenv"
 	"go.temporal.io/sdk/client"
@@ -14,30 +16,28 @@ import (
 )
 
 func main() {
-	// TODO: структура Config
 	_ = godotenv.Load()
-	dsn := os.Getenv("DB_URL")
-	if dsn == "" {
-		user := os.Getenv("DB_USER")
-		password := os.Getenv("DB_PASSWORD")
-		host := os.Getenv("DB_HOST")
-		if host == "" {
-			host = "localhost"
-		}
-		port := os.Getenv("DB_PORT")
-		if port == "" {
-			port = "5433"
-		}
-		name := os.Getenv("DB_NAME")
-		if name == "" {
-			name = "downloader"
-		}
-		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, password, host, port, name)
-	}
-	repo, err := repository.NewPostgresRepository(dsn)
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	repo, err := app.OpenRepository(cfg)
 	if err != nil {
 		log.Fatalf("Failed to init repository: %v", err)
 	}
+	blobs, err := app.OpenBlobStore(context.Background(), cfg, repo)
+	if err != nil {
+		log.Fatalf("Failed to init blob store: %v", err)
+	}
+
+	compression, err := app.CompressionPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Invalid compression settings: %v", err)
+	}
+	keys, err := app.OpenKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
 
 	c, err := client.Dial(client.Options{})
 	if err != nil {
@@ -45,13 +45,23 @@ func main() {
 	}
 	defer c.Close()
 
-	w := worker.New(c, "file-storage-tasks", worker.Options{})
+	w := worker.New(c, cfg.TaskQueue, worker.Options{})
 
 	w.RegisterWorkflow(temporal.DownloadWorkflow)
+	w.RegisterWorkflow(temporal.RetentionWorkflow)
 
-	activityContainer := &temporal.Activities{Repo: repo}
+	activityContainer := &temporal.Activities{
+		Repo: repo, Blobs: blobs, MaxFileSize: cfg.MaxFileSize, Compression: compression, Keys: keys, Signals: c,
+	}
+	if cfg.WebhookSecret != "" {
+		activityContainer.Webhooks = webhook.NewSender(cfg.WebhookSecret)
+	}
 	w.RegisterActivity(activityContainer)
 
+	if err := temporaladapter.EnsureRetentionSchedule(context.Background(), c, cfg.TaskQueue, cfg.GCInterval, cfg.GCBatchSize); err != nil {
+		log.Fatalf("Failed to set up retention schedule: %v", err)
+	}
+
 	log.Println("Worker is starting...")
 	err = w.Run(worker.InterruptCh())
 	if err != nil {
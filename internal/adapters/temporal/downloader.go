@@ -2,11 +2,17 @@ package temporaladapter
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
+	"async-file-storage/internal/domain"
 	"async-file-storage/internal/temporal"
 
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
 )
 
@@ -19,15 +25,80 @@ func NewDownloader(c client.Client, taskQueue string) *Downloader {
 	return &Downloader{client: c, taskQueue: taskQueue}
 }
 
-func (d *Downloader) StartDownload(ctx context.Context, requestID int, urls []string, timeout time.Duration) error {
+// StartDownload starts the download workflow of a request. The workflow ID is derived from
+// the request ID and the idempotency key, if there is one. Only RetryDownload and AddFiles
+// reuse a workflow ID, and ExecuteWorkflow reports a start under an ID that is already taken as
+// success, so repeated starts do nothing.
+func (d *Downloader) StartDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
 	options := client.StartWorkflowOptions{
-		ID:        fmt.Sprintf("download_request_%d", requestID),
-		TaskQueue: d.taskQueue,
+		ID:                    workflowID(requestID, idempotencyKey),
+		TaskQueue:             d.taskQueue,
+		WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
 	}
 
-	_, err := d.client.ExecuteWorkflow(ctx, options, temporal.DownloadWorkflow, requestID, urls, timeout)
+	_, err := d.client.ExecuteWorkflow(ctx, options, temporal.DownloadWorkflow, requestID, files, timeout)
 	if err != nil {
 		return fmt.Errorf("execute workflow: %w", err)
 	}
 	return nil
 }
+
+// RetryDownload starts a new run of the download workflow of a finished request. It reuses
+// the workflow ID, so CancelDownload reaches the new run. A previous run can only still be
+// running while it wraps up after recording the final status, so it is terminated.
+func (d *Downloader) RetryDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	options := client.StartWorkflowOptions{
+		ID:                       workflowID(requestID, idempotencyKey),
+		TaskQueue:                d.taskQueue,
+		WorkflowIDReusePolicy:    enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+		WorkflowIDConflictPolicy: enumspb.WORKFLOW_ID_CONFLICT_POLICY_TERMINATE_EXISTING,
+	}
+
+	_, err := d.client.ExecuteWorkflow(ctx, options, temporal.DownloadWorkflow, requestID, files, timeout)
+	if err != nil {
+		return fmt.Errorf("execute workflow: %w", err)
+	}
+	return nil
+}
+
+// AddFiles signals the download workflow of a request to download more files. When the
+// workflow has completed, a new run is started with the signal instead, so the files
+// are never left behind.
+func (d *Downloader) AddFiles(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	id := workflowID(requestID, idempotencyKey)
+	options := client.StartWorkflowOptions{
+		ID:                    id,
+		TaskQueue:             d.taskQueue,
+		WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+
+	_, err := d.client.SignalWithStartWorkflow(ctx, id, temporal.AddFilesSignal, files, options,
+		temporal.DownloadWorkflow, requestID, []domain.FileRef(nil), timeout)
+	if err != nil {
+		return fmt.Errorf("signal workflow: %w", err)
+	}
+	return nil
+}
+
+// CancelDownload asks the download workflow of a request to stop. It returns
+// domain.ErrNotFound when there is no running workflow to cancel.
+func (d *Downloader) CancelDownload(ctx context.Context, requestID int, idempotencyKey string) error {
+	err := d.client.CancelWorkflow(ctx, workflowID(requestID, idempotencyKey), "")
+	if notFound := (*serviceerror.NotFound)(nil); errors.As(err, &notFound) {
+		return domain.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("cancel workflow: %w", err)
+	}
+	return nil
+}
+
+func workflowID(requestID int, idempotencyKey string) string {
+	if idempotencyKey == "" {
+		return fmt.Sprintf("download_request_%d", requestID)
+	}
+	// Hashing keeps arbitrary client keys within the characters and length Temporal accepts.
+	// The request ID stays in, because a key is free again once its request is deleted.
+	sum := sha256.Sum256([]byte(idempotencyKey))
+	return fmt.Sprintf("download_request_%d_key_%s", requestID, hex.EncodeToString(sum[:16]))
+}
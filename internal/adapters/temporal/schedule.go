@@ -0,0 +1,57 @@
+package temporaladapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"async-file-storage/internal/temporal"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+	sdktemporal "go.temporal.io/sdk/temporal"
+)
+
+const retentionScheduleID = "retention-gc"
+
+// EnsureRetentionSchedule creates the schedule that runs RetentionWorkflow every interval,
+// or updates it when it already exists, so a changed config takes effect on restart.
+func EnsureRetentionSchedule(ctx context.Context, c client.Client, taskQueue string, interval time.Duration, batchSize int) error {
+	spec := client.ScheduleSpec{
+		Intervals: []client.ScheduleIntervalSpec{{Every: interval}},
+	}
+	action := &client.ScheduleWorkflowAction{
+		ID:        retentionScheduleID,
+		Workflow:  temporal.RetentionWorkflow,
+		Args:      []interface{}{batchSize},
+		TaskQueue: taskQueue,
+	}
+
+	_, err := c.ScheduleClient().Create(ctx, client.ScheduleOptions{
+		ID:      retentionScheduleID,
+		Spec:    spec,
+		Action:  action,
+		Overlap: enumspb.SCHEDULE_OVERLAP_POLICY_SKIP,
+	})
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sdktemporal.ErrScheduleAlreadyRunning) {
+		return fmt.Errorf("create retention schedule: %w", err)
+	}
+
+	handle := c.ScheduleClient().GetHandle(ctx, retentionScheduleID)
+	err = handle.Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			schedule := input.Description.Schedule
+			schedule.Spec = &spec
+			schedule.Action = action
+			return &client.ScheduleUpdate{Schedule: &schedule}, nil
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("update retention schedule: %w", err)
+	}
+	return nil
+}
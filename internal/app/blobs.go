@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"async-file-storage/internal/blobstore"
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/repository"
+)
+
+// OpenBlobStore builds the blob store selected by BLOB_STORE.
+func OpenBlobStore(ctx context.Context, cfg config.Config, repo Repository) (domain.BlobStore, error) {
+	switch cfg.BlobStore {
+	case config.BlobStoreFS:
+		return blobstore.NewFSStore(cfg.BlobDir)
+	case config.BlobStorePostgres:
+		pg, ok := repo.(*repository.PostgresRepository)
+		if !ok {
+			return nil, fmt.Errorf("blob store %q requires storage %q", cfg.BlobStore, config.StoragePostgres)
+		}
+		return pg.Blobs(), nil
+	case config.BlobStoreS3:
+		return blobstore.NewS3Store(ctx, blobstore.S3Options{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			Prefix:    cfg.S3.Prefix,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			UseSSL:    cfg.S3.UseSSL,
+			PathStyle: cfg.S3.PathStyle,
+			PartSize:  cfg.S3.PartSize,
+
+			UnsignedPayload: cfg.S3.Unsigned,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
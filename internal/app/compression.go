@@ -0,0 +1,19 @@
+package app
+
+import (
+	"async-file-storage/internal/codec"
+	"async-file-storage/internal/config"
+)
+
+// CompressionPolicy builds the policy the worker stores downloads with.
+func CompressionPolicy(cfg config.Config) (codec.Policy, error) {
+	encoding, err := codec.ParseEncoding(cfg.Compression)
+	if err != nil {
+		return codec.Policy{}, err
+	}
+	types := cfg.CompressionTypes
+	if len(types) == 0 {
+		types = codec.DefaultContentTypes
+	}
+	return codec.Policy{Encoding: encoding, MinSize: cfg.CompressionMinSize, ContentTypes: types}, nil
+}
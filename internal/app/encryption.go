@@ -0,0 +1,15 @@
+package app
+
+import (
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/envelope"
+)
+
+// OpenKeyring loads the master keys named by ENCRYPTION_KEY_FILE. It returns nil
+// when encryption is not configured.
+func OpenKeyring(cfg config.Config) (*envelope.Keyring, error) {
+	if cfg.EncryptionKeyFile == "" {
+		return nil, nil
+	}
+	return envelope.LoadKeyring(cfg.EncryptionKeyFile)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/events"
+	"async-file-storage/internal/repository"
+)
+
+// eventPollInterval is how often event streams look for new events when the
+// repository cannot notify about them.
+const eventPollInterval = time.Second
+
+// OpenEventHub builds the hub that wakes event streams until ctx is done. With
+// postgres it listens to the notifications the repository sends, so events recorded
+// by any worker reach the streams of every API instance; other storages are polled.
+func OpenEventHub(ctx context.Context, cfg config.Config) (*events.Hub, error) {
+	hub := events.NewHub()
+	if cfg.Storage == config.StoragePostgres {
+		if err := hub.ListenPostgres(ctx, cfg.DatabaseURL, repository.EventsChannel); err != nil {
+			return nil, fmt.Errorf("listen for events: %w", err)
+		}
+		return hub, nil
+	}
+	go hub.Poll(ctx, eventPollInterval)
+	return hub, nil
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"fmt"
+
+	"async-file-storage/internal/config"
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
+	"async-file-storage/internal/repository"
+	"async-file-storage/internal/usecase"
+)
+
+// Repository is implemented by every metadata backend. The API uses it as
+// usecase.Repository and the worker as domain.Storage; rotate-keys uses it as
+// envelope.KeyStore.
+type Repository interface {
+	domain.Storage
+	usecase.Repository
+	envelope.KeyStore
+}
+
+// OpenRepository builds the repository selected by STORAGE.
+func OpenRepository(cfg config.Config) (Repository, error) {
+	switch cfg.Storage {
+	case config.StoragePostgres:
+		return repository.NewPostgresRepository(cfg.DatabaseURL)
+	case config.StorageSQLite:
+		return repository.NewSQLiteRepository(cfg.SQLitePath)
+	case config.StorageMemory:
+		return repository.NewMemoryRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
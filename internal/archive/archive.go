@@ -0,0 +1,106 @@
+// Package archive streams downloaded files into a single ZIP or tar.gz archive.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format is an archive format, named by the extension of its files.
+type Format string
+
+const (
+	FormatZip   Format = "zip"
+	FormatTarGz Format = "tar.gz"
+)
+
+// ParseFormat accepts the supported formats; the empty string means zip.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "", FormatZip:
+		return FormatZip, nil
+	case FormatTarGz, "tgz":
+		return FormatTarGz, nil
+	default:
+		return "", fmt.Errorf("unknown archive format %q", s)
+	}
+}
+
+// ContentType is the media type of archives in the format.
+func (f Format) ContentType() string {
+	if f == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// Writer writes archive entries one after the other, straight to the underlying writer.
+type Writer interface {
+	// Create starts an entry of exactly size bytes. The returned writer is valid
+	// until the next call to Create or Close.
+	Create(name string, size int64, modTime time.Time) (io.Writer, error)
+	// Close finishes the archive but does not close the underlying writer.
+	Close() error
+}
+
+// NewWriter returns a Writer producing an archive in the given format.
+func NewWriter(format Format, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatZip:
+		return zipWriter{zip.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z zipWriter) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	// Sizes and the checksum follow each entry in a data descriptor, so nothing is buffered.
+	return z.zw.CreateHeader(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		Modified:           modTime,
+		UncompressedSize64: uint64(size),
+	})
+}
+
+func (z zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.tw, nil
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
@@ -0,0 +1,30 @@
+package archive
+
+import "time"
+
+// ManifestName is the entry every archive ends with.
+const ManifestName = "manifest.json"
+
+// Manifest describes every file of the request, including the ones that are not
+// in the archive and why.
+type Manifest struct {
+	RequestID int             `json:"request_id"`
+	Status    string          `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	ID    int    `json:"file_id"`
+	URL   string `json:"url"`
+	State string `json:"state"`
+	// Name is the entry holding the content; empty for files not in the archive.
+	Name   string         `json:"name,omitempty"`
+	Size   *int64         `json:"size,omitempty"`
+	SHA256 string         `json:"sha256,omitempty"`
+	Error  *ManifestError `json:"error,omitempty"`
+}
+
+type ManifestError struct {
+	Code string `json:"code"`
+}
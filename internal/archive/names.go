@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxNameLength keeps entry names within what common file systems accept.
+const maxNameLength = 200
+
+// EntryName picks the base name of a file inside an archive: the Content-Disposition
+// filename if the origin sent one, otherwise the last segment of the URL path, and
+// file-<id> when neither yields a usable name. The result never contains a directory.
+func EntryName(fileName, rawURL string, id int) string {
+	if name := cleanName(fileName); name != "" {
+		return name
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := cleanName(path.Base(u.Path)); name != "" {
+			return name
+		}
+	}
+	return "file-" + strconv.Itoa(id)
+}
+
+// cleanName strips directories and characters that are unsafe in file names.
+func cleanName(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// Leading dots would hide the file or, as "..", climb out of the target directory.
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	name = strings.TrimRight(name, ". ")
+	if len(name) > maxNameLength {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxNameLength-len(ext)], "") + ext
+	}
+	return name
+}
+
+// Names hands out entry names that are unique within one archive, ignoring case
+// so that archives also extract cleanly on case-insensitive file systems.
+type Names struct {
+	used map[string]bool
+}
+
+// NewNames returns a Names that will not hand out any of the reserved names.
+func NewNames(reserved ...string) *Names {
+	n := &Names{used: make(map[string]bool)}
+	for _, name := range reserved {
+		n.used[strings.ToLower(name)] = true
+	}
+	return n
+}
+
+// Unique returns name, or name with " (2)", " (3)", ... inserted before the
+// extension if it is already taken.
+func (n *Names) Unique(name string) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		base, ext = name, ""
+	}
+	for i := 2; n.used[strings.ToLower(candidate)]; i++ {
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	n.used[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -0,0 +1,46 @@
+package archive_test
+
+import (
+	"testing"
+
+	"async-file-storage/internal/archive"
+)
+
+func TestEntryName(t *testing.T) {
+	tests := []struct {
+		fileName, url string
+		want          string
+	}{
+		{"report.pdf", "https://example.com/download?id=1", "report.pdf"},
+		{"", "https://example.com/files/data%20set.csv?x=1", "data set.csv"},
+		{"../../etc/passwd", "https://example.com/a", "passwd"},
+		{`C:\Users\me\notes.txt`, "", "notes.txt"},
+		{"..", "https://example.com/", "file-7"},
+		{"", "https://example.com/..", "file-7"},
+		{"a\x00b:c.txt", "", "a_b_c.txt"},
+		{".hidden", "", "hidden"},
+	}
+	for _, tt := range tests {
+		if got := archive.EntryName(tt.fileName, tt.url, 7); got != tt.want {
+			t.Errorf("EntryName(%q, %q) = %q, want %q", tt.fileName, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNames_Unique(t *testing.T) {
+	names := archive.NewNames(archive.ManifestName)
+	got := []string{
+		names.Unique("report.csv"),
+		names.Unique("Report.CSV"),
+		names.Unique("report.csv"),
+		names.Unique("manifest.json"),
+		names.Unique("README"),
+		names.Unique("README"),
+	}
+	want := []string{"report.csv", "Report (2).CSV", "report (3).csv", "manifest (2).json", "README", "README (2)"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"async-file-storage/internal/domain"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options configures an S3-compatible object store.
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	PathStyle bool
+	// PartSize is the multipart chunk size. Payloads larger than one part are
+	// uploaded in parts, so at most one part is buffered at a time.
+	PartSize uint64
+	// UnsignedPayload skips the aws-chunked payload signature used on plain
+	// HTTP endpoints, for S3-compatible servers that do not implement it.
+	UnsignedPayload bool
+}
+
+// S3Store keeps blobs as objects in an S3-compatible bucket.
+type S3Store struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+	unsigned bool
+}
+
+// NewS3Store connects to the object store and checks that the bucket exists.
+func NewS3Store(ctx context.Context, opts S3Options) (*S3Store, error) {
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check bucket: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %q does not exist", opts.Bucket)
+	}
+
+	return &S3Store{client: client, bucket: opts.Bucket, prefix: opts.Prefix, partSize: opts.PartSize, unsigned: opts.UnsignedPayload}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	info, err := s.client.PutObject(ctx, s.bucket, s.objectName(key), r, -1, minio.PutObjectOptions{
+		ContentType:          "application/octet-stream",
+		PartSize:             s.partSize,
+		DisableContentSha256: s.unsigned,
+		SendContentMd5:       s.unsigned,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("put object: %w", err)
+	}
+	return info.Size, nil
+}
+
+// Get returns the object as a seekable reader; seeking turns into ranged GET requests.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.objectName(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+	// GetObject is lazy; Stat surfaces a missing object before the caller starts streaming.
+	if _, err := obj.Stat(); err != nil {
+		_ = obj.Close()
+		return nil, mapS3Error(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (domain.BlobInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		return domain.BlobInfo{}, mapS3Error(err)
+	}
+	return domain.BlobInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, s.objectName(key), minio.RemoveObjectOptions{}); err != nil {
+		return mapS3Error(err)
+	}
+	return nil
+}
+
+func (s *S3Store) objectName(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func mapS3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return domain.ErrNotFound
+	}
+	return fmt.Errorf("s3: %w", err)
+}
@@ -0,0 +1,65 @@
+package blobstore_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"async-file-storage/internal/blobstore"
+	"async-file-storage/internal/domain"
+)
+
+func TestFSStore_PutGetStatDelete(t *testing.T) {
+	ctx := context.Background()
+	store, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+
+	n, err := store.Put(ctx, "requests/1/a", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 bytes written, got %d", n)
+	}
+
+	rc, err := store.Get(ctx, "requests/1/a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "hello" {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	info, err := store.Stat(ctx, "requests/1/a")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size != 5 {
+		t.Fatalf("expected size 5, got %d", info.Size)
+	}
+
+	if err := store.Delete(ctx, "requests/1/a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := store.Get(ctx, "requests/1/a"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestFSStore_RejectsEscapingKeys(t *testing.T) {
+	store, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, key := range []string{"", "/etc/passwd", "../outside", "a//b"} {
+		if _, err := store.Put(context.Background(), key, strings.NewReader("x")); err == nil {
+			t.Fatalf("expected error for key %q", key)
+		}
+	}
+}
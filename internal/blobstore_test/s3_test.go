@@ -0,0 +1,126 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"async-file-storage/internal/blobstore"
+	"async-file-storage/internal/domain"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func newS3Store(t *testing.T, prefix string) *blobstore.S3Store {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("downloads"); err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	srv := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(srv.Close)
+
+	store, err := blobstore.NewS3Store(context.Background(), blobstore.S3Options{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "downloads",
+		Prefix:    prefix,
+		AccessKey: "test",
+		SecretKey: "test",
+		PathStyle: true,
+		PartSize:  5 << 20,
+		// The fake server does not decode aws-chunked bodies.
+		UnsignedPayload: true,
+	})
+	if err != nil {
+		t.Fatalf("new s3 store: %v", err)
+	}
+	return store
+}
+
+func TestS3Store_PutGetStatDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newS3Store(t, "files")
+
+	n, err := store.Put(ctx, "requests/1/a", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 bytes written, got %d", n)
+	}
+
+	rc, err := store.Get(ctx, "requests/1/a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	if string(data) != "hello" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if _, err := rc.Seek(3, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	data, _ = io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "lo" {
+		t.Fatalf("unexpected content after seek %q", data)
+	}
+
+	info, err := store.Stat(ctx, "requests/1/a")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size != 5 {
+		t.Fatalf("expected size 5, got %d", info.Size)
+	}
+
+	if err := store.Delete(ctx, "requests/1/a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := store.Get(ctx, "requests/1/a"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := store.Stat(ctx, "requests/1/a"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from stat, got %v", err)
+	}
+}
+
+func TestS3Store_MultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	store := newS3Store(t, "")
+
+	payload := make([]byte, 11<<20)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("generate payload: %v", err)
+	}
+
+	// Hide the length from the client so it has to fall back to a streaming multipart upload.
+	n, err := store.Put(ctx, "big", io.MultiReader(bytes.NewReader(payload)))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if n != int64(len(payload)) {
+		t.Fatalf("expected %d bytes written, got %d", len(payload), n)
+	}
+
+	rc, err := store.Get(ctx, "big")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("downloaded content differs from uploaded content")
+	}
+}
@@ -0,0 +1,198 @@
+// Package codec compresses blobs at rest and decodes them again when they are read.
+package codec
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"async-file-storage/internal/domain"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Policy decides which downloads are compressed before they are stored.
+type Policy struct {
+	// Encoding is applied to matching content; EncodingIdentity disables compression.
+	Encoding domain.ContentEncoding
+	// MinSize skips content whose declared length is below it. Content of unknown
+	// length is always compressed.
+	MinSize int64
+	// ContentTypes lists the compressible media types. An entry ending in "/" matches
+	// a whole top-level type ("text/"), one starting with "+" a structured syntax
+	// suffix ("+json"), anything else a single media type.
+	ContentTypes []string
+}
+
+// DefaultContentTypes are the text formats that usually compress well.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/x-ndjson",
+	"application/xml",
+	"application/javascript",
+	"application/yaml",
+	"application/csv",
+	"+json",
+	"+xml",
+}
+
+// Choose returns the encoding for content of the given type and declared length (-1 if unknown).
+func (p Policy) Choose(contentType string, length int64) domain.ContentEncoding {
+	if p.Encoding == "" || p.Encoding == domain.EncodingIdentity {
+		return domain.EncodingIdentity
+	}
+	if length >= 0 && length < p.MinSize {
+		return domain.EncodingIdentity
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return domain.EncodingIdentity
+	}
+	for _, pattern := range p.ContentTypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "":
+			continue
+		case strings.HasSuffix(pattern, "/") && strings.HasPrefix(mediaType, pattern),
+			strings.HasPrefix(pattern, "+") && strings.HasSuffix(mediaType, pattern),
+			mediaType == pattern:
+			return p.Encoding
+		}
+	}
+	return domain.EncodingIdentity
+}
+
+// ParseEncoding accepts the encodings a blob may be stored with. "none" and the
+// empty string mean identity.
+func ParseEncoding(s string) (domain.ContentEncoding, error) {
+	switch enc := domain.ContentEncoding(strings.ToLower(s)); enc {
+	case "", "none", domain.EncodingIdentity:
+		return domain.EncodingIdentity, nil
+	case domain.EncodingGzip, domain.EncodingZstd:
+		return enc, nil
+	default:
+		return "", fmt.Errorf("unknown content encoding %q", s)
+	}
+}
+
+// NewWriter returns a writer that encodes into w. Close flushes the encoder but does not close w.
+func NewWriter(enc domain.ContentEncoding, w io.Writer) (io.WriteCloser, error) {
+	switch enc {
+	case "", domain.EncodingIdentity:
+		return nopWriteCloser{w}, nil
+	case domain.EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case domain.EncodingZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown content encoding %q", enc)
+	}
+}
+
+// NewReader returns a reader that decodes r. Close releases the decoder but does not close r.
+func NewReader(enc domain.ContentEncoding, r io.Reader) (io.ReadCloser, error) {
+	switch enc {
+	case "", domain.EncodingIdentity:
+		return io.NopCloser(r), nil
+	case domain.EncodingGzip:
+		return gzip.NewReader(r)
+	case domain.EncodingZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown content encoding %q", enc)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewSeekableReader decodes src, whose decoded length is size, behind an
+// io.ReadSeekCloser. Seeking forward discards decoded bytes and seeking backward
+// decodes again from the start, so range requests work at the cost of CPU.
+// Closing it closes src.
+func NewSeekableReader(enc domain.ContentEncoding, src io.ReadSeekCloser, size int64) io.ReadSeekCloser {
+	if enc == "" || enc == domain.EncodingIdentity {
+		return src
+	}
+	return &seekableReader{enc: enc, src: src, size: size}
+}
+
+type seekableReader struct {
+	enc  domain.ContentEncoding
+	src  io.ReadSeekCloser
+	size int64
+
+	dec io.ReadCloser
+	pos int64 // position of dec in the decoded stream
+	off int64 // position the next Read starts at
+}
+
+func (r *seekableReader) Read(p []byte) (int, error) {
+	if r.dec == nil || r.off < r.pos {
+		if err := r.restart(); err != nil {
+			return 0, err
+		}
+	}
+	if r.off > r.pos {
+		n, err := io.CopyN(io.Discard, r.dec, r.off-r.pos)
+		r.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.dec.Read(p)
+	r.pos += int64(n)
+	r.off = r.pos
+	return n, err
+}
+
+func (r *seekableReader) restart() error {
+	if r.dec != nil {
+		_ = r.dec.Close()
+		r.dec = nil
+	}
+	if _, err := r.src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec, err := NewReader(r.enc, r.src)
+	if err != nil {
+		return err
+	}
+	r.dec, r.pos = dec, 0
+	return nil
+}
+
+func (r *seekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("codec: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("codec: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *seekableReader) Close() error {
+	if r.dec != nil {
+		_ = r.dec.Close()
+	}
+	return r.src.Close()
+}
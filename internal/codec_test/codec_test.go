@@ -0,0 +1,109 @@
+package codec_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"async-file-storage/internal/codec"
+	"async-file-storage/internal/domain"
+)
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+func encode(t *testing.T, enc domain.ContentEncoding, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(enc, &buf)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := strings.Repeat("timestamp,level,message\n", 1000)
+	for _, enc := range []domain.ContentEncoding{domain.EncodingIdentity, domain.EncodingGzip, domain.EncodingZstd} {
+		t.Run(string(enc), func(t *testing.T) {
+			stored := encode(t, enc, data)
+			if enc != domain.EncodingIdentity && len(stored) >= len(data)/5 {
+				t.Fatalf("expected repetitive text to shrink, got %d of %d bytes", len(stored), len(data))
+			}
+			r, err := codec.NewReader(enc, bytes.NewReader(stored))
+			if err != nil {
+				t.Fatalf("new reader: %v", err)
+			}
+			defer r.Close()
+			if got, err := io.ReadAll(r); err != nil || string(got) != data {
+				t.Fatalf("round trip failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSeekableReader(t *testing.T) {
+	data := "0123456789abcdefghij"
+	stored := encode(t, domain.EncodingZstd, data)
+	r := codec.NewSeekableReader(domain.EncodingZstd, nopCloser{bytes.NewReader(stored)}, int64(len(data)))
+	defer r.Close()
+
+	if end, err := r.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+		t.Fatalf("expected size %d from SeekEnd, got %d (%v)", len(data), end, err)
+	}
+	read := func(off int64, n int) string {
+		t.Helper()
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatalf("seek: %v", err)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("read at %d: %v", off, err)
+		}
+		return string(buf)
+	}
+	// Forward, backward and forward again.
+	if got := read(10, 5); got != "abcde" {
+		t.Fatalf("unexpected bytes %q", got)
+	}
+	if got := read(2, 3); got != "234" {
+		t.Fatalf("unexpected bytes after seeking back %q", got)
+	}
+	if got := read(15, 5); got != "fghij" {
+		t.Fatalf("unexpected bytes %q", got)
+	}
+}
+
+func TestPolicyChoose(t *testing.T) {
+	p := codec.Policy{Encoding: domain.EncodingZstd, MinSize: 1024, ContentTypes: codec.DefaultContentTypes}
+	cases := []struct {
+		contentType string
+		length      int64
+		want        domain.ContentEncoding
+	}{
+		{"text/csv; charset=utf-8", 4096, domain.EncodingZstd},
+		{"application/json", -1, domain.EncodingZstd},
+		{"application/vnd.api+json", 4096, domain.EncodingZstd},
+		{"text/plain", 100, domain.EncodingIdentity},
+		{"image/png", 4096, domain.EncodingIdentity},
+		{"", 4096, domain.EncodingIdentity},
+	}
+	for _, c := range cases {
+		if got := p.Choose(c.contentType, c.length); got != c.want {
+			t.Errorf("Choose(%q, %d): expected %s, got %s", c.contentType, c.length, c.want, got)
+		}
+	}
+	if got := (codec.Policy{}).Choose("text/plain", 4096); got != domain.EncodingIdentity {
+		t.Errorf("expected the zero policy to store content as is, got %s", got)
+	}
+}
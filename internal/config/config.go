@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+
+	BlobStoreFS       = "fs"
+	BlobStorePostgres = "postgres"
+	BlobStoreS3       = "s3"
+)
+
+// Config holds the settings shared by the API server and the worker.
+type Config struct {
+	// Storage selects the metadata repository: postgres, sqlite or memory.
+	Storage     string
+	DatabaseURL string
+	// SQLitePath is the database file used when Storage is sqlite.
+	SQLitePath string
+	HTTPAddr   string
+	TaskQueue  string
+
+	BlobStore string
+	BlobDir   string
+	S3        S3Config
+
+	// MaxFileSize is the largest file the worker will download, in bytes. Zero disables the limit.
+	MaxFileSize int64
+
+	// Compression is the encoding compressible content is stored with: none, gzip or zstd.
+	Compression string
+	// CompressionMinSize skips content declared smaller than this many bytes.
+	CompressionMinSize int64
+	// CompressionTypes lists the media types worth compressing; empty uses the built-in list.
+	CompressionTypes []string
+
+	// EncryptionKeyFile is the JSON file holding the master keys; empty stores content unencrypted.
+	EncryptionKeyFile string
+
+	// DefaultRetention applies to requests created without expires_at; zero keeps them forever.
+	DefaultRetention time.Duration
+	// MaxRetention caps the expires_at a client may ask for; zero means no cap.
+	MaxRetention time.Duration
+	// GCInterval is how often the retention workflow purges expired requests.
+	GCInterval time.Duration
+	// GCBatchSize is the number of requests purged per activity call.
+	GCBatchSize int
+
+	// RejectDuplicateURLs refuses requests that list the same URL more than once.
+	RejectDuplicateURLs bool
+
+	// WebhookSecret signs completion webhooks; empty disables callback_url.
+	WebhookSecret string
+
+	// invalid collects the settings that were set but did not parse; see Validate.
+	invalid []error
+}
+
+// S3Config describes the S3-compatible bucket used when BLOB_STORE=s3.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	PathStyle bool
+	PartSize  uint64
+	Unsigned  bool
+}
+
+// Load reads the configuration from the environment, filling in defaults.
+// Call godotenv.Load before it to pick up a .env file, and Validate after it.
+func Load() Config {
+	var env strictEnv
+	cfg := Config{
+		Storage:     getEnv("STORAGE", StoragePostgres),
+		DatabaseURL: databaseURL(),
+		SQLitePath:  getEnv("SQLITE_PATH", "data/downloader.db"),
+		HTTPAddr:    ":" + getEnv("API_PORT", "8080"),
+		TaskQueue:   getEnv("TASK_QUEUE", "file-storage-tasks"),
+		BlobStore:   getEnv("BLOB_STORE", BlobStoreFS),
+		BlobDir:     getEnv("BLOB_DIR", "data/blobs"),
+		S3: S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "localhost:9000"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    getEnv("S3_BUCKET", "downloads"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    env.bool("S3_USE_SSL", false),
+			PathStyle: env.bool("S3_PATH_STYLE", true),
+			PartSize:  uint64(env.int("S3_PART_SIZE", 16<<20)),
+			Unsigned:  env.bool("S3_UNSIGNED_PAYLOAD", false),
+		},
+		MaxFileSize: env.int("MAX_FILE_SIZE", 1<<30),
+
+		Compression:        getEnv("COMPRESSION", "none"),
+		CompressionMinSize: env.int("COMPRESSION_MIN_SIZE", 1024),
+		CompressionTypes:   getList("COMPRESSION_TYPES"),
+
+		EncryptionKeyFile: os.Getenv("ENCRYPTION_KEY_FILE"),
+
+		DefaultRetention: env.duration("RETENTION_DEFAULT", 0),
+		MaxRetention:     env.duration("RETENTION_MAX", 90*24*time.Hour),
+		GCInterval:       env.duration("GC_INTERVAL", time.Hour),
+		GCBatchSize:      int(env.int("GC_BATCH_SIZE", 100)),
+
+		RejectDuplicateURLs: env.bool("REJECT_DUPLICATE_URLS", false),
+
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+	}
+	cfg.invalid = env.errs
+	return cfg
+}
+
+// Validate reports the settings that were set but did not parse, and those out of range.
+// The services refuse to start on an invalid configuration rather than guess, as some of
+// the settings decide where content is stored and when data is deleted.
+func (c Config) Validate() error {
+	errs := slices.Clone(c.invalid)
+	if c.MaxFileSize < 0 {
+		errs = append(errs, fmt.Errorf("MAX_FILE_SIZE must not be negative, got %d", c.MaxFileSize))
+	}
+	if int64(c.S3.PartSize) <= 0 {
+		errs = append(errs, fmt.Errorf("S3_PART_SIZE must be positive, got %d", int64(c.S3.PartSize)))
+	}
+	if c.CompressionMinSize < 0 {
+		errs = append(errs, fmt.Errorf("COMPRESSION_MIN_SIZE must not be negative, got %d", c.CompressionMinSize))
+	}
+	if c.DefaultRetention < 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_DEFAULT must not be negative, got %s", c.DefaultRetention))
+	}
+	if c.MaxRetention < 0 {
+		errs = append(errs, fmt.Errorf("RETENTION_MAX must not be negative, got %s", c.MaxRetention))
+	}
+	if c.GCInterval <= 0 {
+		errs = append(errs, fmt.Errorf("GC_INTERVAL must be positive, got %s", c.GCInterval))
+	}
+	if c.GCBatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("GC_BATCH_SIZE must be positive, got %d", c.GCBatchSize))
+	}
+	return errors.Join(errs...)
+}
+
+// databaseURL uses DB_URL if present, otherwise builds the DSN from the DB_* parts.
+func databaseURL() string {
+	if dsn := os.Getenv("DB_URL"); dsn != "" {
+		return dsn
+	}
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		getEnv("DB_HOST", "localhost"),
+		getEnv("DB_PORT", "5433"),
+		getEnv("DB_NAME", "downloader"),
+	)
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getList splits a comma-separated value, dropping empty items.
+func getList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// strictEnv reads the settings that are not plain strings. A value that is set but does
+// not parse does not fall back to the default silently: the error is kept for Validate.
+type strictEnv struct {
+	errs []error
+}
+
+func (e *strictEnv) bool(key string, fallback bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: expected true or false", key, raw))
+		return fallback
+	}
+	return value
+}
+
+func (e *strictEnv) duration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: expected a duration such as 720h", key, raw))
+		return fallback
+	}
+	return value
+}
+
+func (e *strictEnv) int(key string, fallback int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: expected an integer", key, raw))
+		return fallback
+	}
+	return value
+}
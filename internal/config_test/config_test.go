@@ -0,0 +1,53 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/config"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %v", err)
+	}
+	if cfg.DefaultRetention != 0 || cfg.GCInterval != time.Hour || cfg.GCBatchSize != 100 {
+		t.Fatalf("unexpected retention defaults: %+v", cfg)
+	}
+}
+
+func TestValidate_RejectsRetentionSettings(t *testing.T) {
+	for key, value := range map[string]string{
+		"RETENTION_DEFAULT": "30 days",
+		"RETENTION_MAX":     "-1h",
+		"GC_INTERVAL":       "0s",
+		"GC_BATCH_SIZE":     "many",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			err := config.Load().Validate()
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Fatalf("expected an error naming %s, got %v", key, err)
+			}
+		})
+	}
+}
+
+func TestValidate_RejectsStorageSettings(t *testing.T) {
+	for key, value := range map[string]string{
+		"S3_USE_SSL":    "yes",
+		"S3_PATH_STYLE": "off",
+		"S3_PART_SIZE":  "16MiB",
+		"MAX_FILE_SIZE": "1GB",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			err := config.Load().Validate()
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Fatalf("expected an error naming %s, got %v", key, err)
+			}
+		})
+	}
+}
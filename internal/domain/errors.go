@@ -2,4 +2,14 @@ package domain
 
 import "errors"
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrGone is returned for requests that expired and were purged.
+	ErrGone = errors.New("gone")
+	// ErrIdempotencyMismatch is returned when an idempotency key is reused for a different request.
+	ErrIdempotencyMismatch = errors.New("idempotency key was used for a different request")
+	// ErrInProgress is returned for changes that need a finished request.
+	ErrInProgress = errors.New("request is still in progress")
+	// ErrFinished is returned for changes that need a request in progress.
+	ErrFinished = errors.New("request has already finished")
+)
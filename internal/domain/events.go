@@ -0,0 +1,47 @@
+package domain
+
+import "time"
+
+// EventType names a change in the event stream of a request.
+type EventType string
+
+const (
+	EventFileStarted   EventType = "file.started"
+	EventFileProgress  EventType = "file.progress"
+	EventFileSucceeded EventType = "file.succeeded"
+	// EventFileFailed covers every state a file ends in without content.
+	EventFileFailed       EventType = "file.failed"
+	EventRequestCompleted EventType = "request.completed"
+)
+
+// Event is one change of a request. Events of a request are numbered in the
+// order their changes were committed.
+type Event struct {
+	ID        int64
+	RequestID int
+	Type      EventType
+	// FileID is zero for request events.
+	FileID int
+	// State and Error are the final state and error code of a file.
+	State FileState
+	Error string
+	// Status is the status a request completed with.
+	Status Status
+	// BytesDownloaded and BytesTotal are set for progress and for downloaded files.
+	BytesDownloaded int64
+	BytesTotal      int64
+	CreatedAt       time.Time
+}
+
+// FileEvent returns the type of the event a file moving to state records, if any.
+func FileEvent(state FileState) (EventType, bool) {
+	switch {
+	case state == FileDownloading:
+		return EventFileStarted, true
+	case state == FileSucceeded:
+		return EventFileSucceeded, true
+	case state.Finished():
+		return EventFileFailed, true
+	}
+	return "", false
+}
@@ -1,10 +1,39 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type Storage interface {
-	CreateRequest(ctx context.Context, urls []string) (int, error)
+	// CreateRequest stores a new request and returns its id and its files in the order of req.URLs.
+	CreateRequest(ctx context.Context, req NewRequest) (int, []FileRef, error)
 	UpdateRequestStatus(ctx context.Context, id int, status Status) error
-	UpdateFileStatus(ctx context.Context, requestID int, url string, data []byte, downloadErr error) error
+	// UpdateFileStatus moves the file to state and records the error code and whatever metadata was collected.
+	// Files that succeed go through SaveFileContent instead.
+	UpdateFileStatus(ctx context.Context, requestID, fileID int, state FileState, meta FileMeta, downloadErr error) error
+	// SaveFileContent attaches downloaded content and its metadata to the file and marks it SUCCEEDED. If a blob with the same hash
+	// already exists it is shared and its storage key returned instead of content.StorageKey.
+	// Keys of blobs that lost their last reference are returned for deletion.
+	SaveFileContent(ctx context.Context, requestID, fileID int, content StoredContent, meta FileMeta) (string, []string, error)
+	// RecordProgress records how far the downloads of files still DOWNLOADING have got.
+	RecordProgress(ctx context.Context, requestID int, progress []FileProgress) error
 	GetRequestStatus(ctx context.Context, id int) (*DownloadRequest, []FileEntry, error)
+	// RecordDelivery records an attempt to deliver the webhook of a request.
+	RecordDelivery(ctx context.Context, delivery WebhookDelivery) error
+	// ListExpiredRequests returns up to limit finished requests whose retention ended before now.
+	ListExpiredRequests(ctx context.Context, now time.Time, limit int) ([]int, error)
+	// PurgeRequest deletes the request like DeleteRequest and leaves a tombstone, so later
+	// lookups report ErrGone. It returns the storage keys of blobs that lost their last reference.
+	PurgeRequest(ctx context.Context, id int) ([]string, error)
+}
+
+// BlobStore keeps the content of downloaded files. Keys are slash-separated
+// paths chosen by the caller; the metadata lives in Storage.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	Delete(ctx context.Context, key string) error
 }
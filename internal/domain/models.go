@@ -1,25 +1,258 @@
 package domain
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Status string
 
 const (
 	StatusProcess Status = "PROCESS"
-	StatusDone    Status = "DONE"
-	StatusError   Status = "ERROR"
+	// StatusDone means every file was downloaded.
+	StatusDone Status = "DONE"
+	// StatusPartial means some files were downloaded and some were not.
+	StatusPartial Status = "PARTIAL"
+	// StatusError means no file was downloaded, or the workflow itself failed.
+	StatusError Status = "ERROR"
+	// StatusCanceled means the client stopped the request before it finished.
+	StatusCanceled Status = "CANCELED"
 )
 
+// FileState is the lifecycle state of one file:
+// PENDING -> DOWNLOADING -> SUCCEEDED / FAILED / CANCELED, or PENDING -> SKIPPED / CANCELED.
+// A file canceled while downloading keeps the metadata collected so far.
+type FileState string
+
+const (
+	FilePending     FileState = "PENDING"
+	FileDownloading FileState = "DOWNLOADING"
+	FileSucceeded   FileState = "SUCCEEDED"
+	FileFailed      FileState = "FAILED"
+	// FileSkipped is a file that was never attempted, for instance because the request timed out first.
+	FileSkipped FileState = "SKIPPED"
+	// FileCanceled is a file that had not finished when the client canceled the request.
+	FileCanceled FileState = "CANCELED"
+)
+
+// Finished reports whether the file has reached a final state.
+func (s FileState) Finished() bool {
+	switch s {
+	case FileSucceeded, FileFailed, FileSkipped, FileCanceled:
+		return true
+	}
+	return false
+}
+
+// Retryable reports whether the file ended without content and may be downloaded again.
+func (s FileState) Retryable() bool {
+	switch s {
+	case FileFailed, FileSkipped, FileCanceled:
+		return true
+	}
+	return false
+}
+
+// AggregateStatus derives the status of a finished request from the states of its files.
+func AggregateStatus(files []FileEntry) Status {
+	succeeded := 0
+	for _, f := range files {
+		if f.State == FileSucceeded {
+			succeeded++
+		}
+	}
+	switch {
+	case succeeded == len(files):
+		return StatusDone
+	case succeeded == 0:
+		return StatusError
+	}
+	return StatusPartial
+}
+
 type DownloadRequest struct {
 	ID        int
 	Status    Status
 	CreatedAt time.Time
+	// ExpiresAt is nil for requests that are kept forever.
+	ExpiresAt *time.Time
+	// IdempotencyKey is the key the request was created with, if any.
+	IdempotencyKey string
+	// Timeout is the download timeout the request was created with; zero if it was not recorded.
+	Timeout time.Duration
+	// CallbackURL is told when the request finishes; empty if nobody is.
+	CallbackURL string
 }
 
-type FileEntry struct {
+// Expired reports whether the request is past its retention at the given time. A request
+// still downloading does not expire before it has finished, as its workflow still writes it.
+func (r DownloadRequest) Expired(now time.Time) bool {
+	return r.Status != StatusProcess && r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// NewRequest holds everything needed to create a download request.
+type NewRequest struct {
+	URLs []string
+	// Checksums holds the digests the client expects, Checksums[i] for URLs[i].
+	// It may be nil or shorter than URLs.
+	Checksums []Digests
+	Timeout   time.Duration
+	ExpiresAt *time.Time
+	// IdempotencyKey, if set, makes creation idempotent: a request already created
+	// with the same key is returned instead of a new one, provided it has the same
+	// Fingerprint.
+	IdempotencyKey string
+	Fingerprint    string
+	CallbackURL    string
+}
+
+// ChecksumsAt returns the expected digests of the i-th URL.
+func (r NewRequest) ChecksumsAt(i int) Digests {
+	if i < len(r.Checksums) {
+		return r.Checksums[i]
+	}
+	return Digests{}
+}
+
+// FileRef identifies one file of a request. The download pipeline carries it so that
+// every write targets a single row, also when a request lists the same URL twice.
+type FileRef struct {
+	ID  int
+	URL string
+	// Checksums are the digests the downloaded content has to match.
+	Checksums Digests
+}
+
+// Digests are hex-encoded digests of a file's content. An empty field is not known.
+type Digests struct {
+	SHA256 string
+	SHA512 string
+	MD5    string
+}
+
+// Matches reports whether every digest set in expected equals the one in d, ignoring case.
+func (d Digests) Matches(expected Digests) bool {
+	return digestMatches(d.SHA256, expected.SHA256) &&
+		digestMatches(d.SHA512, expected.SHA512) &&
+		digestMatches(d.MD5, expected.MD5)
+}
+
+func digestMatches(actual, expected string) bool {
+	return expected == "" || strings.EqualFold(actual, expected)
+}
+
+// RequestFilter narrows down ListRequests. Zero fields do not filter.
+type RequestFilter struct {
+	Status Status
+	// CreatedFrom is inclusive, CreatedTo exclusive.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// URLContains matches requests with a file URL containing it, ignoring case.
+	URLContains string
+	// Host matches requests with a file on this host, ignoring case.
+	Host string
+	// ActiveAt leaves out requests that had expired by then.
+	ActiveAt *time.Time
+}
+
+// RequestCursor is the position of a request in the (created_at, id) order.
+type RequestCursor struct {
+	CreatedAt time.Time
 	ID        int
-	RequestID int
-	URL       string
-	Data      []byte
+}
+
+// ListRequestsQuery asks for one page of requests ordered by creation time, ties broken by id.
+type ListRequestsQuery struct {
+	Filter     RequestFilter
+	Descending bool
+	// After continues the listing behind the given request.
+	After *RequestCursor
+	Limit int
+}
+
+type FileEntry struct {
+	ID          int
+	RequestID   int
+	URL         string
+	StorageKey  string
+	Size        int64
+	ContentHash string
+	Encoding    ContentEncoding // of the blob at StorageKey; Size is the decoded size
+	DataKey     DataKey
+	// Checksums are the digests the client expects.
+	Checksums Digests
+	State     FileState
 	Error     string
+	// Attempts counts how often a download of the file was started.
+	Attempts int
+	FileMeta
+}
+
+// FileMeta is what the origin told us about a file while it was downloaded.
+// It is recorded for failed downloads too, as far as the download got.
+type FileMeta struct {
+	ContentType string
+	// FileName comes from the Content-Disposition header, without any directory part.
+	FileName   string
+	HTTPStatus int
+	// FinalURL is the URL the content was served from after redirects.
+	FinalURL   string
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	// Digests are computed over the downloaded bytes: always SHA-256, and the other
+	// algorithms the client asked to verify.
+	Digests Digests
+}
+
+// ContentEncoding is how a blob is encoded at rest. The names are the HTTP
+// Content-Encoding tokens, so stored bytes can be served as they are.
+type ContentEncoding string
+
+const (
+	EncodingIdentity ContentEncoding = "identity"
+	EncodingGzip     ContentEncoding = "gzip"
+	EncodingZstd     ContentEncoding = "zstd"
+)
+
+// DataKey is the key a blob is encrypted with, wrapped by the master key
+// MasterKeyID. The zero value marks a blob stored in plaintext.
+type DataKey struct {
+	MasterKeyID string
+	Wrapped     []byte
+}
+
+// Encrypted reports whether the blob is encrypted.
+func (k DataKey) Encrypted() bool {
+	return k.MasterKeyID != ""
+}
+
+// BlobKey pairs a content blob with its data key, for key rotation.
+type BlobKey struct {
+	Hash string
+	Key  DataKey
+}
+
+// FileProgress is how far the download of a file has got.
+type FileProgress struct {
+	FileID          int
+	BytesDownloaded int64
+	// BytesTotal is the Content-Length the origin announced; zero if it announced none.
+	BytesTotal int64
+	StartedAt  time.Time
+}
+
+// StoredContent describes an uploaded blob. Hash and Size refer to the
+// downloaded bytes, before any encoding or encryption.
+type StoredContent struct {
+	Hash       string
+	StorageKey string
+	Size       int64
+	Encoding   ContentEncoding
+	DataKey    DataKey
+}
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
 }
@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// DeliveryStatus is the outcome of one attempt to deliver a webhook.
+type DeliveryStatus string
+
+const (
+	DeliverySucceeded DeliveryStatus = "SUCCEEDED"
+	DeliveryFailed    DeliveryStatus = "FAILED"
+)
+
+// WebhookDelivery is one attempt to tell the callback URL of a request that it finished.
+type WebhookDelivery struct {
+	ID        int64
+	RequestID int
+	URL       string
+	// Attempt counts the attempts of one delivery from 1; every completion of the
+	// request is delivered anew.
+	Attempt int
+	Status  DeliveryStatus
+	// HTTPStatus is zero when no response arrived.
+	HTTPStatus int
+	Error      string
+	Duration   time.Duration
+	CreatedAt  time.Time
+}
@@ -0,0 +1,139 @@
+// Package envelope encrypts blobs with per-blob data keys that are wrapped by
+// master keys from a local key file.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"async-file-storage/internal/domain"
+)
+
+// KeySize is the length of master and data keys: both are AES-256 keys.
+const KeySize = 32
+
+// ErrUnknownKey is returned for data keys wrapped by a master key the keyring does not hold.
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Keyring holds the master keys. New data keys are wrapped by the active one;
+// the others are kept to unwrap data keys that have not been rotated yet.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// keyFile is the format of the key file:
+//
+//	{"active": "2026-10", "keys": {"2026-01": "<base64>", "2026-10": "<base64>"}}
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads the master keys from a key file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return ParseKeyring(data)
+}
+
+// ParseKeyring parses the contents of a key file.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse key file: %w", err)
+	}
+	if _, ok := f.Keys[f.Active]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the key file", f.Active)
+	}
+
+	k := &Keyring{active: f.Active, keys: make(map[string]cipher.AEAD, len(f.Keys))}
+	for id, encoded := range f.Keys {
+		if id == "" {
+			return nil, errors.New("key file contains a key without an id")
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if len(raw) != KeySize {
+			return nil, fmt.Errorf("key %q: expected %d bytes, got %d", id, KeySize, len(raw))
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// ActiveKeyID names the master key new data keys are wrapped with.
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// NewDataKey returns a random data key in plaintext and wrapped by the active master key.
+func (k *Keyring) NewDataKey() ([]byte, domain.DataKey, error) {
+	plain := make([]byte, KeySize)
+	if _, err := rand.Read(plain); err != nil {
+		return nil, domain.DataKey{}, err
+	}
+	wrapped, err := k.wrap(k.active, plain)
+	if err != nil {
+		return nil, domain.DataKey{}, err
+	}
+	return plain, wrapped, nil
+}
+
+// Unwrap recovers the plaintext data key.
+func (k *Keyring) Unwrap(dk domain.DataKey) ([]byte, error) {
+	aead, ok := k.keys[dk.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, dk.MasterKeyID)
+	}
+	size := aead.NonceSize()
+	if len(dk.Wrapped) < size {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	plain, err := aead.Open(nil, dk.Wrapped[:size], dk.Wrapped[size:], []byte(dk.MasterKeyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return plain, nil
+}
+
+// Rewrap wraps the data key with the active master key. The payload it protects is unchanged.
+func (k *Keyring) Rewrap(dk domain.DataKey) (domain.DataKey, error) {
+	plain, err := k.Unwrap(dk)
+	if err != nil {
+		return domain.DataKey{}, err
+	}
+	return k.wrap(k.active, plain)
+}
+
+// wrap seals the data key with a master key; the key id is authenticated with it.
+func (k *Keyring) wrap(id string, plain []byte) (domain.DataKey, error) {
+	aead := k.keys[id]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return domain.DataKey{}, err
+	}
+	return domain.DataKey{MasterKeyID: id, Wrapped: aead.Seal(nonce, nonce, plain, []byte(id))}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
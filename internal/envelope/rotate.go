@@ -0,0 +1,47 @@
+package envelope
+
+import (
+	"context"
+	"fmt"
+
+	"async-file-storage/internal/domain"
+)
+
+// KeyStore is the part of the repository key rotation works on.
+type KeyStore interface {
+	// ListStaleDataKeys returns up to limit encrypted blobs whose data key is not
+	// wrapped by the master key activeKeyID.
+	ListStaleDataKeys(ctx context.Context, activeKeyID string, limit int) ([]domain.BlobKey, error)
+	// ReplaceDataKey stores a re-wrapped data key for the blob with the given hash,
+	// unless the blob no longer uses old.
+	ReplaceDataKey(ctx context.Context, hash string, old, replacement domain.DataKey) error
+}
+
+// Rotate re-wraps every data key that is not wrapped by the active master key.
+// Payloads are not touched. It returns the number of re-wrapped keys; once it
+// reports no error, master keys other than the active one are no longer needed.
+func Rotate(ctx context.Context, store KeyStore, keys *Keyring, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+	total := 0
+	for {
+		stale, err := store.ListStaleDataKeys(ctx, keys.ActiveKeyID(), batchSize)
+		if err != nil {
+			return total, fmt.Errorf("list stale data keys: %w", err)
+		}
+		for _, blob := range stale {
+			replacement, err := keys.Rewrap(blob.Key)
+			if err != nil {
+				return total, fmt.Errorf("rewrap data key of %s: %w", blob.Hash, err)
+			}
+			if err := store.ReplaceDataKey(ctx, blob.Hash, blob.Key, replacement); err != nil {
+				return total, fmt.Errorf("store data key of %s: %w", blob.Hash, err)
+			}
+			total++
+		}
+		if len(stale) < batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A blob is encrypted in independent chunks so that it can be written as a stream
+// and read from any offset:
+//
+//	version (1 byte) | chunk 0 | chunk 1 | ... | chunk n-1
+//
+// Every chunk but the last holds chunkSize plaintext bytes and is sealed with
+// AES-GCM. The nonce is the chunk index followed by a flag set only on the last
+// chunk, so chunks cannot be reordered, dropped or truncated unnoticed. Nonces
+// never repeat because every blob has its own data key.
+const (
+	formatVersion = 1
+	chunkSize     = 64 << 10
+	tagSize       = 16
+	sealedChunk   = chunkSize + tagSize
+)
+
+var header = []byte{formatVersion}
+
+// NewWriter encrypts everything written to it into w with the data key.
+// Close seals the final chunk but does not close w.
+func NewWriter(key []byte, w io.Writer) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{aead: aead, w: w, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+type writer struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	buf     []byte
+	index   uint64
+	started bool
+	err     error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is held back until more data arrives, because only
+		// Close knows which chunk is the last.
+		if len(w.buf) == chunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return written, w.err
+			}
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.seal(true)
+	if w.err == nil {
+		w.err = errors.New("envelope: writer is closed")
+		return nil
+	}
+	return w.err
+}
+
+func (w *writer) seal(last bool) error {
+	if !w.started {
+		if _, err := w.w.Write(header); err != nil {
+			return err
+		}
+		w.started = true
+	}
+	sealed := w.aead.Seal(nil, chunkNonce(w.index, last), w.buf, header)
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// PlaintextSize returns the length of the content in an encrypted blob of the given size.
+func PlaintextSize(size int64) (int64, error) {
+	body := size - int64(len(header))
+	if body < tagSize {
+		return 0, errors.New("envelope: blob is too short")
+	}
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	plain := body - chunks*tagSize
+	if plain < 0 || (chunks > 1 && plain <= (chunks-1)*chunkSize) {
+		return 0, errors.New("envelope: blob has an invalid size")
+	}
+	return plain, nil
+}
+
+// NewReader decrypts src, an encrypted blob of size bytes. Each Read decrypts at most
+// one chunk, and Seek only moves the position, so range reads touch the chunks they
+// need. Closing it closes src.
+func NewReader(key []byte, src io.ReadSeekCloser, size int64) (io.ReadSeekCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := PlaintextSize(size)
+	if err != nil {
+		return nil, err
+	}
+	chunks := int64(1)
+	if plain > 0 {
+		chunks = (plain + chunkSize - 1) / chunkSize
+	}
+	return &reader{aead: aead, src: src, size: plain, chunks: chunks, cached: -1}, nil
+}
+
+type reader struct {
+	aead   cipher.AEAD
+	src    io.ReadSeekCloser
+	size   int64
+	chunks int64
+
+	off    int64
+	cached int64 // index of the chunk in buf, or -1
+	buf    []byte
+	sealed []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		// An empty blob still carries one chunk, which authenticates it.
+		if r.size == 0 && r.cached < 0 {
+			if err := r.load(0); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	index := r.off / chunkSize
+	if index != r.cached {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.off-index*chunkSize:])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *reader) load(index int64) error {
+	r.cached = -1
+	if _, err := r.src.Seek(int64(len(header))+index*sealedChunk, io.SeekStart); err != nil {
+		return err
+	}
+	length := int64(sealedChunk)
+	if last := index == r.chunks-1; last {
+		length = r.size - index*chunkSize + tagSize
+	}
+	if cap(r.sealed) < int(length) {
+		r.sealed = make([]byte, length)
+	}
+	r.sealed = r.sealed[:length]
+	if _, err := io.ReadFull(r.src, r.sealed); err != nil {
+		return fmt.Errorf("envelope: read chunk %d: %w", index, err)
+	}
+	if index == 0 {
+		if err := r.checkHeader(); err != nil {
+			return err
+		}
+	}
+	plain, err := r.aead.Open(r.buf[:0], chunkNonce(uint64(index), index == r.chunks-1), r.sealed, header)
+	if err != nil {
+		return fmt.Errorf("envelope: chunk %d failed authentication: %w", index, err)
+	}
+	r.buf, r.cached = plain, index
+	return nil
+}
+
+func (r *reader) checkHeader() error {
+	version := make([]byte, len(header))
+	if _, err := r.src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r.src, version); err != nil {
+		return err
+	}
+	if version[0] != formatVersion {
+		return fmt.Errorf("envelope: unsupported format version %d", version[0])
+	}
+	return nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("envelope: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("envelope: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	return r.src.Close()
+}
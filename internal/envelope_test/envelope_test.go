@@ -0,0 +1,229 @@
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
+	"async-file-storage/internal/repository"
+)
+
+const chunkSize = 64 << 10
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+func keyFile(t *testing.T, active string, ids ...string) []byte {
+	t.Helper()
+	keys := ""
+	for i, id := range ids {
+		if i > 0 {
+			keys += ","
+		}
+		// Derive the key from the id so the same id means the same key across keyrings.
+		key := bytes.Repeat([]byte(id[:1]), envelope.KeySize)
+		keys += fmt.Sprintf("%q:%q", id, base64.StdEncoding.EncodeToString(key))
+	}
+	return []byte(fmt.Sprintf(`{"active":%q,"keys":{%s}}`, active, keys))
+}
+
+func mustKeyring(t *testing.T, data []byte) *envelope.Keyring {
+	t.Helper()
+	k, err := envelope.ParseKeyring(data)
+	if err != nil {
+		t.Fatalf("parse keyring: %v", err)
+	}
+	return k
+}
+
+func encrypt(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := envelope.NewWriter(key, &buf)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	r, err := envelope.NewReader(key, nopCloser{bytes.NewReader(sealed)}, int64(len(sealed)))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseKeyring_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not json":       `{`,
+		"missing active": `{"active":"b","keys":{"a":"` + base64.StdEncoding.EncodeToString(make([]byte, 32)) + `"}}`,
+		"short key":      `{"active":"a","keys":{"a":"` + base64.StdEncoding.EncodeToString(make([]byte, 16)) + `"}}`,
+		"bad base64":     `{"active":"a","keys":{"a":"!!"}}`,
+	} {
+		if _, err := envelope.ParseKeyring([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestKeyring_WrapAndRewrap(t *testing.T) {
+	oldRing := mustKeyring(t, keyFile(t, "a", "a"))
+	plain, dk, err := oldRing.NewDataKey()
+	if err != nil {
+		t.Fatalf("new data key: %v", err)
+	}
+	if dk.MasterKeyID != "a" || bytes.Contains(dk.Wrapped, plain) {
+		t.Fatalf("unexpected wrapped key %+v", dk)
+	}
+
+	newRing := mustKeyring(t, keyFile(t, "b", "a", "b"))
+	rotated, err := newRing.Rewrap(dk)
+	if err != nil {
+		t.Fatalf("rewrap: %v", err)
+	}
+	if rotated.MasterKeyID != "b" {
+		t.Fatalf("expected the active key, got %q", rotated.MasterKeyID)
+	}
+	if got, err := newRing.Unwrap(rotated); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("rotated key does not unwrap to the data key: %v", err)
+	}
+	if _, err := oldRing.Unwrap(rotated); !errors.Is(err, envelope.ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+	// The key id is authenticated, so a relabelled key does not unwrap.
+	if _, err := newRing.Unwrap(domain.DataKey{MasterKeyID: "b", Wrapped: dk.Wrapped}); err == nil {
+		t.Fatal("expected a relabelled key to fail")
+	}
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	key := randomBytes(t, envelope.KeySize)
+	for _, n := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		data := randomBytes(t, n)
+		sealed := encrypt(t, key, data)
+		if size, err := envelope.PlaintextSize(int64(len(sealed))); err != nil || size != int64(n) {
+			t.Fatalf("%d bytes: plaintext size %d (%v)", n, size, err)
+		}
+		got, err := decrypt(key, sealed)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: round trip failed: %v", n, err)
+		}
+	}
+}
+
+func TestStream_Seek(t *testing.T) {
+	key := randomBytes(t, envelope.KeySize)
+	data := randomBytes(t, 2*chunkSize+100)
+	sealed := encrypt(t, key, data)
+	r, err := envelope.NewReader(key, nopCloser{bytes.NewReader(sealed)}, int64(len(sealed)))
+	if err != nil {
+		t.Fatalf("new reader: %v", err)
+	}
+	defer r.Close()
+
+	for _, off := range []int64{chunkSize - 10, 5, 2 * chunkSize, int64(len(data)) - 3} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatalf("seek %d: %v", off, err)
+		}
+		got := make([]byte, 20)
+		n, err := io.ReadFull(r, got)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("read at %d: %v", off, err)
+		}
+		if !bytes.Equal(got[:n], data[off:min(off+20, int64(len(data)))]) {
+			t.Fatalf("wrong bytes at %d", off)
+		}
+	}
+	if end, err := r.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+		t.Fatalf("seek to end: %d (%v)", end, err)
+	}
+}
+
+func TestStream_DetectsTampering(t *testing.T) {
+	key := randomBytes(t, envelope.KeySize)
+	sealed := encrypt(t, key, randomBytes(t, 2*chunkSize))
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)/2] ^= 1
+	if _, err := decrypt(key, flipped); err == nil {
+		t.Fatal("expected a modified chunk to fail")
+	}
+	// Dropping the last chunk leaves a valid-looking blob whose new last chunk lacks the flag.
+	if _, err := decrypt(key, sealed[:1+chunkSize+16]); err == nil {
+		t.Fatal("expected a truncated blob to fail")
+	}
+	if _, err := decrypt(randomBytes(t, envelope.KeySize), sealed); err == nil {
+		t.Fatal("expected the wrong key to fail")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	oldRing := mustKeyring(t, keyFile(t, "a", "a"))
+
+	plains := make(map[string][]byte)
+	for i := range 5 {
+		id, files, err := repo.CreateRequest(ctx, domain.NewRequest{URLs: []string{fmt.Sprintf("https://example.com/%d", i)}})
+		if err != nil {
+			t.Fatalf("create request: %v", err)
+		}
+		plain, dk, err := oldRing.NewDataKey()
+		if err != nil {
+			t.Fatalf("new data key: %v", err)
+		}
+		hash := fmt.Sprintf("hash-%d", i)
+		plains[hash] = plain
+		if _, _, err := repo.SaveFileContent(ctx, id, files[0].ID,
+			domain.StoredContent{Hash: hash, StorageKey: "content/" + hash, Size: 1, DataKey: dk}, domain.FileMeta{}); err != nil {
+			t.Fatalf("save content: %v", err)
+		}
+	}
+
+	newRing := mustKeyring(t, keyFile(t, "b", "a", "b"))
+	n, err := envelope.Rotate(ctx, repo, newRing, 2)
+	if err != nil || n != 5 {
+		t.Fatalf("expected 5 rotated keys, got %d (%v)", n, err)
+	}
+	// Without the retired key every data key must still unwrap to the same bytes.
+	onlyNew := mustKeyring(t, keyFile(t, "b", "b"))
+	for i := range 5 {
+		f, err := repo.GetFile(ctx, i+1, i+1)
+		if err != nil {
+			t.Fatalf("get file: %v", err)
+		}
+		if got, err := onlyNew.Unwrap(f.DataKey); err != nil || !bytes.Equal(got, plains[f.ContentHash]) {
+			t.Fatalf("file %d: data key changed or not rotated: %v", i+1, err)
+		}
+	}
+	if n, err := envelope.Rotate(ctx, repo, newRing, 2); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to rotate, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package events wakes the event streams a server holds open when new events of
+// their requests may have been recorded, possibly by another process.
+package events
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Hub fans wake-ups out to the subscribers of each request. A wake-up carries no
+// event: subscribers read the events from the repository, so a missed or spurious
+// wake-up costs a query and never loses an event.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[int]map[chan struct{}]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[int]map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel that receives a value when events of the request may have
+// been recorded, and the function that ends the subscription. Wake-ups that arrive while
+// one is pending are merged.
+func (h *Hub) Subscribe(requestID int) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	if h.subs[requestID] == nil {
+		h.subs[requestID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[requestID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[requestID], ch)
+		if len(h.subs[requestID]) == 0 {
+			delete(h.subs, requestID)
+		}
+	}
+}
+
+// Notify wakes the subscribers of a request.
+func (h *Hub) Notify(requestID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[requestID] {
+		wake(ch)
+	}
+}
+
+// NotifyAll wakes every subscriber.
+func (h *Hub) NotifyAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for ch := range subs {
+			wake(ch)
+		}
+	}
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Poll wakes every subscriber each interval until ctx is done. It stands in for
+// notifications with backends that cannot send them.
+func (h *Hub) Poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.NotifyAll()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listenerPingInterval is how often an idle listener checks that its connection is alive.
+const listenerPingInterval = time.Minute
+
+// ListenPostgres relays the notifications sent on channel, whose payload is a request
+// id, to the subscribers of that request until ctx is done. The connection is
+// re-established when it drops; every subscriber is woken then, as notifications
+// sent in between are lost.
+func (h *Hub) ListenPostgres(ctx context.Context, dsn, channel string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, nil)
+	if err := listener.Listen(channel); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	go func() {
+		defer func() { _ = listener.Close() }()
+		ping := time.NewTicker(listenerPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case n := <-listener.Notify:
+				if n == nil {
+					h.NotifyAll()
+					continue
+				}
+				if id, err := strconv.Atoi(n.Extra); err == nil {
+					h.Notify(id)
+				}
+			case <-ping.C:
+				go func() { _ = listener.Ping() }()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,66 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"async-file-storage/internal/migrate"
+	"async-file-storage/internal/repository"
+)
+
+func TestLoad_SortsAndPairsFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":   {Data: []byte("CREATE INDEX a ON t (x);")},
+		"0002_add_index.down.sql": {Data: []byte("DROP INDEX a;")},
+		"0001_init.up.sql":        {Data: []byte("CREATE TABLE t (x INT);")},
+		"0001_init.down.sql":      {Data: []byte("DROP TABLE t;")},
+		"README.md":               {Data: []byte("ignored")},
+	}
+
+	migrations, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[0].Name != "init" || migrations[1].Name != "add_index" {
+		t.Fatalf("unexpected order: %+v", migrations)
+	}
+	if migrations[1].Down != "DROP INDEX a;" {
+		t.Fatalf("unexpected down script %q", migrations[1].Down)
+	}
+}
+
+func TestLoad_RejectsInvalidSets(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_init.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"gap": {
+			"0001_init.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_init.down.sql": {Data: []byte("SELECT 1;")},
+			"0003_late.up.sql":   {Data: []byte("SELECT 1;")},
+			"0003_late.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"bad name": {
+			"init.up.sql":   {Data: []byte("SELECT 1;")},
+			"init.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := migrate.Load(fsys); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPostgresMigrationsAreValid(t *testing.T) {
+	migrations, err := migrate.Load(repository.PostgresMigrations())
+	if err != nil {
+		t.Fatalf("load embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expected at least one migration")
+	}
+}
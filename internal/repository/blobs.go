@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"async-file-storage/internal/domain"
+)
+
+// blobChunkSize is the size of one blob_chunks row. Blobs are written and read
+// one chunk at a time, so memory use does not depend on the file size.
+const blobChunkSize = 1 << 20
+
+// PostgresBlobStore keeps file content in the blob_chunks BYTEA table.
+// It is meant for small deployments that do not want a separate file store.
+type PostgresBlobStore struct {
+	db *sql.DB
+}
+
+// Blobs returns a blob store that shares the repository connection pool.
+func (r *PostgresRepository) Blobs() *PostgresBlobStore {
+	return &PostgresBlobStore{db: r.db}
+}
+
+// Put stores the blob in chunks inside one transaction, replacing any previous content.
+func (s *PostgresBlobStore) Put(ctx context.Context, key string, r io.Reader) (n int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM blob_chunks WHERE key = $1", key); err != nil {
+		return 0, fmt.Errorf("failed to delete old chunks: %w", err)
+	}
+
+	now := time.Now()
+	buf := make([]byte, blobChunkSize)
+	for seq := 0; ; seq++ {
+		read, readErr := io.ReadFull(r, buf)
+		if read > 0 || seq == 0 {
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO blob_chunks (key, seq, data, created_at) VALUES ($1, $2, $3, $4)",
+				key, seq, buf[:read], now)
+			if err != nil {
+				return 0, fmt.Errorf("failed to insert chunk: %w", err)
+			}
+			n += int64(read)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			err = fmt.Errorf("read blob: %w", readErr)
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Get returns a seekable reader that fetches chunks lazily.
+func (s *PostgresBlobStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT seq, octet_length(data) FROM blob_chunks WHERE key = $1 ORDER BY seq", key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	c := &chunkReader{ctx: ctx, db: s.db, key: key, cached: -1}
+	for rows.Next() {
+		var ch chunkInfo
+		if err := rows.Scan(&ch.seq, &ch.size); err != nil {
+			return nil, err
+		}
+		ch.offset = c.size
+		c.size += ch.size
+		c.chunks = append(c.chunks, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.chunks) == 0 {
+		return nil, domain.ErrNotFound
+	}
+	return c, nil
+}
+
+func (s *PostgresBlobStore) Stat(ctx context.Context, key string) (domain.BlobInfo, error) {
+	info := domain.BlobInfo{Key: key}
+	var modTime sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(octet_length(data)), 0), MIN(created_at) FROM blob_chunks WHERE key = $1", key,
+	).Scan(&info.Size, &modTime)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	if !modTime.Valid {
+		return domain.BlobInfo{}, domain.ErrNotFound
+	}
+	info.ModTime = modTime.Time
+	return info, nil
+}
+
+func (s *PostgresBlobStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM blob_chunks WHERE key = $1", key)
+	return err
+}
+
+type chunkInfo struct {
+	seq    int
+	offset int64
+	size   int64
+}
+
+// chunkReader reads a blob chunk by chunk. The chunk index is loaded up front,
+// so Seek only moves the position and the next Read fetches the right chunk.
+type chunkReader struct {
+	ctx    context.Context
+	db     *sql.DB
+	key    string
+	chunks []chunkInfo
+	size   int64
+	pos    int64
+	cached int
+	data   []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.pos >= c.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(c.chunks), func(i int) bool {
+		return c.chunks[i].offset+c.chunks[i].size > c.pos
+	})
+	if i != c.cached {
+		err := c.db.QueryRowContext(c.ctx,
+			"SELECT data FROM blob_chunks WHERE key = $1 AND seq = $2", c.key, c.chunks[i].seq,
+		).Scan(&c.data)
+		if err != nil {
+			return 0, fmt.Errorf("read chunk: %w", err)
+		}
+		c.cached = i
+	}
+	n := copy(p, c.data[c.pos-c.chunks[i].offset:])
+	c.pos += int64(n)
+	return n, nil
+}
+
+func (c *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = c.pos + offset
+	case io.SeekEnd:
+		pos = c.size + offset
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	c.pos = pos
+	return pos, nil
+}
+
+func (c *chunkReader) Close() error {
+	c.data = nil
+	c.cached = -1
+	return nil
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"net/url"
+	"strings"
+
+	"async-file-storage/internal/domain"
+)
+
+// urlHost returns the lower-cased host name of a file URL, or "" if it has none.
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// containsPattern turns a substring into a LIKE pattern matched against lower(url).
+// Backslash is the escape character.
+func containsPattern(sub string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(strings.ToLower(sub)) + "%"
+}
+
+// matchesFilter applies a RequestFilter in memory, with the semantics of the SQL query.
+func matchesFilter(req *domain.DownloadRequest, files []*domain.FileEntry, f domain.RequestFilter) bool {
+	if f.Status != "" && req.Status != f.Status {
+		return false
+	}
+	if f.CreatedFrom != nil && req.CreatedAt.Before(*f.CreatedFrom) {
+		return false
+	}
+	if f.CreatedTo != nil && !req.CreatedAt.Before(*f.CreatedTo) {
+		return false
+	}
+	if f.ActiveAt != nil && req.Expired(*f.ActiveAt) {
+		return false
+	}
+	if f.URLContains != "" && !anyFile(files, func(file *domain.FileEntry) bool {
+		return strings.Contains(strings.ToLower(file.URL), strings.ToLower(f.URLContains))
+	}) {
+		return false
+	}
+	if f.Host != "" && !anyFile(files, func(file *domain.FileEntry) bool {
+		return urlHost(file.URL) == strings.ToLower(f.Host)
+	}) {
+		return false
+	}
+	return true
+}
+
+func anyFile(files []*domain.FileEntry, match func(*domain.FileEntry) bool) bool {
+	for _, f := range files {
+		if match(f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"async-file-storage/internal/domain"
+)
+
+// MemoryRepository keeps requests and file metadata in process memory. It follows
+// the semantics of PostgresRepository and is meant for tests and local runs;
+// nothing survives a restart and separate processes do not share state.
+type MemoryRepository struct {
+	mu sync.RWMutex
+
+	lastRequestID int
+	lastFileID    int
+	requests      map[int]*domain.DownloadRequest
+	// files holds the files of each request in id order.
+	files      map[int][]*domain.FileEntry
+	contents   map[string]*memoryContent
+	tombstones map[int]time.Time
+	// idempotency maps idempotency keys to the requests created with them.
+	idempotency map[string]memoryIdempotency
+	lastEventID int64
+	// events holds the events of each request in id order.
+	events map[int][]domain.Event
+
+	lastDeliveryID int64
+	// deliveries holds the webhook delivery attempts of each request in id order.
+	deliveries map[int][]domain.WebhookDelivery
+}
+
+type memoryIdempotency struct {
+	requestID   int
+	fingerprint string
+}
+
+// memoryContent mirrors a content_blobs row.
+type memoryContent struct {
+	storageKey string
+	encoding   domain.ContentEncoding
+	dataKey    domain.DataKey
+	refCount   int
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		requests:   make(map[int]*domain.DownloadRequest),
+		files:      make(map[int][]*domain.FileEntry),
+		contents:   make(map[string]*memoryContent),
+		tombstones: make(map[int]time.Time),
+
+		idempotency: make(map[string]memoryIdempotency),
+		events:      make(map[int][]domain.Event),
+		deliveries:  make(map[int][]domain.WebhookDelivery),
+	}
+}
+
+func (r *MemoryRepository) CreateRequest(_ context.Context, newReq domain.NewRequest) (int, []domain.FileRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if newReq.IdempotencyKey != "" {
+		if prior, ok := r.idempotency[newReq.IdempotencyKey]; ok {
+			if prior.fingerprint != newReq.Fingerprint {
+				return 0, nil, domain.ErrIdempotencyMismatch
+			}
+			var refs []domain.FileRef
+			for _, f := range r.files[prior.requestID] {
+				refs = append(refs, domain.FileRef{ID: f.ID, URL: f.URL, Checksums: f.Checksums})
+			}
+			return prior.requestID, refs, nil
+		}
+	}
+
+	r.lastRequestID++
+	req := &domain.DownloadRequest{
+		ID:        r.lastRequestID,
+		Status:    domain.StatusProcess,
+		CreatedAt: time.Now(),
+		ExpiresAt: copyTime(newReq.ExpiresAt),
+
+		IdempotencyKey: newReq.IdempotencyKey,
+		Timeout:        newReq.Timeout.Truncate(time.Millisecond),
+		CallbackURL:    newReq.CallbackURL,
+	}
+	r.requests[req.ID] = req
+
+	refs := r.addFiles(req.ID, newReq)
+	if newReq.IdempotencyKey != "" {
+		r.idempotency[newReq.IdempotencyKey] = memoryIdempotency{requestID: req.ID, fingerprint: newReq.Fingerprint}
+	}
+	return req.ID, refs, nil
+}
+
+// addFiles appends the URLs of newReq to a request as PENDING files.
+func (r *MemoryRepository) addFiles(requestID int, newReq domain.NewRequest) []domain.FileRef {
+	refs := make([]domain.FileRef, 0, len(newReq.URLs))
+	for i, url := range newReq.URLs {
+		r.lastFileID++
+		checksums := newReq.ChecksumsAt(i)
+		r.files[requestID] = append(r.files[requestID], &domain.FileEntry{
+			ID: r.lastFileID, RequestID: requestID, URL: url, Encoding: domain.EncodingIdentity, Checksums: checksums,
+			State: domain.FilePending,
+		})
+		refs = append(refs, domain.FileRef{ID: r.lastFileID, URL: url, Checksums: checksums})
+	}
+	return refs
+}
+
+func (r *MemoryRepository) AppendFiles(_ context.Context, requestID int, files domain.NewRequest, reopen bool) ([]domain.FileRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, err := r.getRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != domain.StatusProcess {
+		if !reopen {
+			return nil, domain.ErrFinished
+		}
+		r.requests[requestID].Status = domain.StatusProcess
+	}
+	return r.addFiles(requestID, files), nil
+}
+
+// UpdateRequestStatus changes the status of a request. Like the SQL UPDATE,
+// it is a no-op for an unknown id.
+func (r *MemoryRepository) UpdateRequestStatus(_ context.Context, id int, status domain.Status) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req, ok := r.requests[id]; ok {
+		req.Status = status
+		if status != domain.StatusProcess {
+			r.recordEvent(domain.Event{RequestID: id, Type: domain.EventRequestCompleted, Status: status})
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdateFileStatus(_ context.Context, requestID, fileID int, state domain.FileState, meta domain.FileMeta, downloadErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errMsg string
+	if downloadErr != nil {
+		errMsg = downloadErr.Error()
+	}
+	if f := r.file(requestID, fileID); f != nil {
+		f.State = state
+		f.Error = errMsg
+		f.FileMeta = copyMeta(meta)
+		if state == domain.FileDownloading {
+			f.Attempts++
+		}
+		if typ, ok := domain.FileEvent(state); ok {
+			r.recordEvent(domain.Event{RequestID: requestID, Type: typ, FileID: fileID, State: state, Error: errMsg})
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) RecordProgress(_ context.Context, requestID int, progress []domain.FileProgress) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range progress {
+		if f := r.file(requestID, p.FileID); f != nil && f.State == domain.FileDownloading {
+			r.recordEvent(domain.Event{
+				RequestID: requestID, Type: domain.EventFileProgress, FileID: p.FileID,
+				BytesDownloaded: p.BytesDownloaded, BytesTotal: p.BytesTotal,
+			})
+		}
+	}
+	return nil
+}
+
+// recordEvent appends an event to the stream of its request, replacing the progress
+// of the file like the SQL repository does. The caller holds the write lock.
+func (r *MemoryRepository) recordEvent(e domain.Event) {
+	events := r.events[e.RequestID]
+	if e.FileID != 0 {
+		events = slices.DeleteFunc(events, func(prior domain.Event) bool {
+			return prior.FileID == e.FileID && prior.Type == domain.EventFileProgress
+		})
+	}
+	r.lastEventID++
+	e.ID = r.lastEventID
+	e.CreatedAt = time.Now()
+	r.events[e.RequestID] = append(events, e)
+}
+
+func (r *MemoryRepository) ListEvents(_ context.Context, requestID int, afterID int64, limit int) ([]domain.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var events []domain.Event
+	for _, e := range r.events[requestID] {
+		if len(events) == limit {
+			break
+		}
+		if e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (r *MemoryRepository) ListProgress(_ context.Context, requestID int) ([]domain.FileProgress, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var progress []domain.FileProgress
+	for _, e := range r.events[requestID] {
+		if e.Type == domain.EventFileProgress {
+			progress = append(progress, domain.FileProgress{FileID: e.FileID, BytesDownloaded: e.BytesDownloaded, BytesTotal: e.BytesTotal})
+		}
+	}
+	slices.SortFunc(progress, func(a, b domain.FileProgress) int { return a.FileID - b.FileID })
+	return progress, nil
+}
+
+func (r *MemoryRepository) RecordDelivery(_ context.Context, d domain.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastDeliveryID++
+	d.ID = r.lastDeliveryID
+	d.Duration = d.Duration.Truncate(time.Millisecond)
+	d.CreatedAt = time.Now()
+	r.deliveries[d.RequestID] = append(r.deliveries[d.RequestID], d)
+	return nil
+}
+
+func (r *MemoryRepository) ListDeliveries(_ context.Context, requestID int) ([]domain.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.deliveries[requestID]), nil
+}
+
+func (r *MemoryRepository) RetryFiles(_ context.Context, requestID int, fileIDs []int) ([]domain.FileRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, err := r.getRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status == domain.StatusProcess {
+		return nil, domain.ErrInProgress
+	}
+	// Check every file before changing any, as the SQL transaction would roll back.
+	files := make([]*domain.FileEntry, 0, len(fileIDs))
+	for _, id := range fileIDs {
+		f := r.file(requestID, id)
+		if f == nil || !f.State.Retryable() {
+			return nil, fmt.Errorf("file %d: %w", id, domain.ErrNotFound)
+		}
+		files = append(files, f)
+	}
+
+	r.requests[requestID].Status = domain.StatusProcess
+	refs := make([]domain.FileRef, 0, len(files))
+	for _, f := range files {
+		f.State = domain.FilePending
+		f.Error = ""
+		f.FileMeta = domain.FileMeta{}
+		refs = append(refs, domain.FileRef{ID: f.ID, URL: f.URL, Checksums: f.Checksums})
+	}
+	return refs, nil
+}
+
+func (r *MemoryRepository) SaveFileContent(_ context.Context, requestID, fileID int, content domain.StoredContent, meta domain.FileMeta) (string, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.file(requestID, fileID)
+	if f == nil {
+		return "", nil, domain.ErrNotFound
+	}
+
+	blob, ok := r.contents[content.Hash]
+	if !ok {
+		blob = &memoryContent{
+			storageKey: content.StorageKey,
+			encoding:   encodingOrIdentity(content.Encoding),
+			dataKey:    copyDataKey(content.DataKey),
+		}
+		r.contents[content.Hash] = blob
+	}
+	blob.refCount++
+
+	previous := f.ContentHash
+	f.ContentHash = content.Hash
+	f.StorageKey = blob.storageKey
+	f.Encoding = blob.encoding
+	f.DataKey = copyDataKey(blob.dataKey)
+	f.Size = content.Size
+	f.State = domain.FileSucceeded
+	f.Error = ""
+	f.FileMeta = copyMeta(meta)
+	r.recordEvent(domain.Event{
+		RequestID: requestID, Type: domain.EventFileSucceeded, FileID: fileID, State: domain.FileSucceeded,
+		BytesDownloaded: content.Size, BytesTotal: content.Size,
+	})
+
+	var orphaned []string
+	if previous != "" {
+		orphaned = r.releaseContent(previous)
+	}
+	return blob.storageKey, orphaned, nil
+}
+
+// file returns the file of a request by id, or nil. The caller holds the lock.
+func (r *MemoryRepository) file(requestID, fileID int) *domain.FileEntry {
+	for _, f := range r.files[requestID] {
+		if f.ID == fileID {
+			return f
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeleteRequest(_ context.Context, id int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deleteRequest(id, false)
+}
+
+func (r *MemoryRepository) PurgeRequest(_ context.Context, id int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deleteRequest(id, true)
+}
+
+func (r *MemoryRepository) deleteRequest(id int, tombstone bool) ([]string, error) {
+	if _, ok := r.requests[id]; !ok {
+		return nil, domain.ErrNotFound
+	}
+
+	var orphaned []string
+	for _, f := range r.files[id] {
+		switch {
+		case f.ContentHash != "":
+			orphaned = append(orphaned, r.releaseContent(f.ContentHash)...)
+		case f.StorageKey != "":
+			orphaned = append(orphaned, f.StorageKey)
+		}
+	}
+	delete(r.files, id)
+	delete(r.requests, id)
+	delete(r.events, id)
+	delete(r.deliveries, id)
+	// Like the requests row in SQL, the key goes away with the request.
+	for key, prior := range r.idempotency {
+		if prior.requestID == id {
+			delete(r.idempotency, key)
+		}
+	}
+
+	if tombstone {
+		r.tombstones[id] = time.Now()
+	}
+	return orphaned, nil
+}
+
+// releaseContent drops one reference to a content blob and returns its key
+// when that was the last one. The caller holds the write lock.
+func (r *MemoryRepository) releaseContent(hash string) []string {
+	blob, ok := r.contents[hash]
+	if !ok {
+		return nil
+	}
+	blob.refCount--
+	if blob.refCount > 0 {
+		return nil
+	}
+	delete(r.contents, hash)
+	return []string{blob.storageKey}
+}
+
+func (r *MemoryRepository) ListExpiredRequests(_ context.Context, now time.Time, limit int) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var expired []*domain.DownloadRequest
+	for _, req := range r.requests {
+		if req.Expired(now) {
+			expired = append(expired, req)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].ExpiresAt.Equal(*expired[j].ExpiresAt) {
+			return expired[i].ExpiresAt.Before(*expired[j].ExpiresAt)
+		}
+		return expired[i].ID < expired[j].ID
+	})
+
+	if limit >= 0 && len(expired) > limit {
+		expired = expired[:limit]
+	}
+	ids := make([]int, 0, len(expired))
+	for _, req := range expired {
+		ids = append(ids, req.ID)
+	}
+	return ids, nil
+}
+
+func (r *MemoryRepository) ListStaleDataKeys(_ context.Context, activeKeyID string, limit int) ([]domain.BlobKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var blobs []domain.BlobKey
+	for hash, blob := range r.contents {
+		if blob.dataKey.Encrypted() && blob.dataKey.MasterKeyID != activeKeyID {
+			blobs = append(blobs, domain.BlobKey{Hash: hash, Key: copyDataKey(blob.dataKey)})
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Hash < blobs[j].Hash })
+	if limit >= 0 && len(blobs) > limit {
+		blobs = blobs[:limit]
+	}
+	return blobs, nil
+}
+
+func (r *MemoryRepository) ReplaceDataKey(_ context.Context, hash string, old, replacement domain.DataKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	blob, ok := r.contents[hash]
+	if !ok || blob.dataKey.MasterKeyID != old.MasterKeyID || !bytes.Equal(blob.dataKey.Wrapped, old.Wrapped) {
+		return nil
+	}
+	blob.dataKey = copyDataKey(replacement)
+	for _, files := range r.files {
+		for _, f := range files {
+			if f.ContentHash == hash {
+				f.DataKey = copyDataKey(replacement)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) ListRequests(_ context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// before reports whether a sorts before b in ascending (created_at, id) order.
+	before := func(aAt time.Time, aID int, bAt time.Time, bID int) bool {
+		if !aAt.Equal(bAt) {
+			return aAt.Before(bAt)
+		}
+		return aID < bID
+	}
+
+	var matched []*domain.DownloadRequest
+	for _, req := range r.requests {
+		if !matchesFilter(req, r.files[req.ID], query.Filter) {
+			continue
+		}
+		if after := query.After; after != nil {
+			if query.Descending && !before(req.CreatedAt, req.ID, after.CreatedAt, after.ID) {
+				continue
+			}
+			if !query.Descending && !before(after.CreatedAt, after.ID, req.CreatedAt, req.ID) {
+				continue
+			}
+		}
+		matched = append(matched, req)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if query.Descending {
+			i, j = j, i
+		}
+		return before(matched[i].CreatedAt, matched[i].ID, matched[j].CreatedAt, matched[j].ID)
+	})
+
+	if query.Limit >= 0 && len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+	requests := make([]domain.DownloadRequest, 0, len(matched))
+	for _, req := range matched {
+		out := *req
+		out.ExpiresAt = copyTime(req.ExpiresAt)
+		requests = append(requests, out)
+	}
+	return requests, nil
+}
+
+func (r *MemoryRepository) GetRequest(_ context.Context, id int) (*domain.DownloadRequest, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.getRequest(id)
+}
+
+func (r *MemoryRepository) getRequest(id int) (*domain.DownloadRequest, error) {
+	req, ok := r.requests[id]
+	if !ok {
+		if _, purged := r.tombstones[id]; purged {
+			return nil, domain.ErrGone
+		}
+		return nil, domain.ErrNotFound
+	}
+	out := *req
+	out.ExpiresAt = copyTime(req.ExpiresAt)
+	return &out, nil
+}
+
+func (r *MemoryRepository) GetRequestStatus(_ context.Context, id int) (*domain.DownloadRequest, []domain.FileEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	req, err := r.getRequest(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []domain.FileEntry
+	for _, f := range r.files[id] {
+		files = append(files, copyFile(f))
+	}
+	return req, files, nil
+}
+
+func (r *MemoryRepository) GetFile(_ context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if f := r.file(requestID, fileID); f != nil {
+		entry := copyFile(f)
+		return &entry, nil
+	}
+	return nil, domain.ErrNotFound
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func copyFile(f *domain.FileEntry) domain.FileEntry {
+	entry := *f
+	entry.FileMeta = copyMeta(f.FileMeta)
+	entry.DataKey = copyDataKey(f.DataKey)
+	return entry
+}
+
+func copyDataKey(k domain.DataKey) domain.DataKey {
+	k.Wrapped = bytes.Clone(k.Wrapped)
+	return k
+}
+
+func copyMeta(meta domain.FileMeta) domain.FileMeta {
+	meta.StartedAt = copyTime(meta.StartedAt)
+	meta.FinishedAt = copyTime(meta.FinishedAt)
+	return meta
+}
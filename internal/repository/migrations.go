@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"database/sql"
+	"embed"
+	"io/fs"
+
+	"async-file-storage/internal/migrate"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// PostgresMigrations returns the embedded Postgres schema migrations.
+func PostgresMigrations() fs.FS {
+	sub, err := fs.Sub(migrationFiles, "migrations/postgres")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// NewPostgresMigrator returns a migrator for the Postgres schema.
+func NewPostgresMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	return migrate.New(db, PostgresMigrations())
+}
+
+// SQLiteMigrations returns the embedded SQLite schema migrations. They mirror
+// the Postgres ones version by version.
+func SQLiteMigrations() fs.FS {
+	sub, err := fs.Sub(migrationFiles, "migrations/sqlite")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// NewSQLiteMigrator returns a migrator for the SQLite schema.
+func NewSQLiteMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	return migrate.New(db, SQLiteMigrations())
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS blob_chunks;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS requests;
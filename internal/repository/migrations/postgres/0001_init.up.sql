@@ -0,0 +1,52 @@
+-- Baseline schema. Statements are idempotent so databases created by
+-- versions that built the schema on startup can be adopted as they are.
+
+CREATE TABLE IF NOT EXISTS requests (
+    id SERIAL PRIMARY KEY,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS files (
+    id SERIAL PRIMARY KEY,
+    request_id INTEGER REFERENCES requests(id),
+    url TEXT NOT NULL,
+    storage_key TEXT,
+    size BIGINT,
+    error_msg TEXT
+);
+ALTER TABLE files ADD COLUMN IF NOT EXISTS storage_key TEXT;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS size BIGINT;
+
+CREATE TABLE IF NOT EXISTS blob_chunks (
+    key TEXT NOT NULL,
+    seq INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (key, seq)
+);
+
+-- Content stored by older versions, either in files.data or in blob_data,
+-- moves to blob_chunks so it stays readable through the postgres blob store.
+DO $$
+BEGIN
+    IF to_regclass('blob_data') IS NOT NULL THEN
+        INSERT INTO blob_chunks (key, seq, data, created_at)
+        SELECT key, 0, data, created_at FROM blob_data
+        ON CONFLICT (key, seq) DO NOTHING;
+        DROP TABLE blob_data;
+    END IF;
+
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'files' AND column_name = 'data'
+    ) THEN
+        INSERT INTO blob_chunks (key, seq, data, created_at)
+        SELECT 'legacy/' || id, 0, data, NOW() FROM files
+        WHERE data IS NOT NULL AND storage_key IS NULL
+        ON CONFLICT (key, seq) DO NOTHING;
+        UPDATE files SET storage_key = 'legacy/' || id, size = length(data)
+        WHERE data IS NOT NULL AND storage_key IS NULL;
+        ALTER TABLE files DROP COLUMN data;
+    END IF;
+END $$;
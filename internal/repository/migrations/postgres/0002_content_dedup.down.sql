@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS files_content_hash_idx;
+ALTER TABLE files DROP COLUMN IF EXISTS content_hash;
+DROP TABLE IF EXISTS content_blobs;
@@ -0,0 +1,13 @@
+-- Downloaded content is stored once per SHA-256 digest. Files point at the
+-- shared blob; ref_count tracks how many files do, and the row is removed
+-- together with the last reference.
+CREATE TABLE content_blobs (
+    hash TEXT PRIMARY KEY,
+    storage_key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE files ADD COLUMN content_hash TEXT REFERENCES content_blobs(hash);
+CREATE INDEX files_content_hash_idx ON files (content_hash);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS request_tombstones;
+DROP INDEX IF EXISTS requests_expires_at_idx;
+ALTER TABLE requests DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,10 @@
+-- Requests expire at expires_at and are purged by the retention workflow.
+-- NULL means the request is kept forever, which applies to existing rows.
+ALTER TABLE requests ADD COLUMN expires_at TIMESTAMP;
+CREATE INDEX requests_expires_at_idx ON requests (expires_at) WHERE expires_at IS NOT NULL;
+
+-- Purged request ids are remembered so the API can answer 410 Gone instead of 404.
+CREATE TABLE request_tombstones (
+    id INTEGER PRIMARY KEY,
+    purged_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,6 @@
+ALTER TABLE files DROP COLUMN IF EXISTS finished_at;
+ALTER TABLE files DROP COLUMN IF EXISTS started_at;
+ALTER TABLE files DROP COLUMN IF EXISTS final_url;
+ALTER TABLE files DROP COLUMN IF EXISTS http_status;
+ALTER TABLE files DROP COLUMN IF EXISTS file_name;
+ALTER TABLE files DROP COLUMN IF EXISTS content_type;
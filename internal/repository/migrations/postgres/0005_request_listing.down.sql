@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS files_host_idx;
+ALTER TABLE files DROP COLUMN IF EXISTS host;
+DROP INDEX IF EXISTS files_request_id_idx;
+DROP INDEX IF EXISTS requests_status_created_at_idx;
+DROP INDEX IF EXISTS requests_created_at_idx;
@@ -0,0 +1,11 @@
+-- Indexes for listing requests with keyset pagination on (created_at, id),
+-- optionally narrowed down by status or by the host of one of their files.
+CREATE INDEX requests_created_at_idx ON requests (created_at, id);
+CREATE INDEX requests_status_created_at_idx ON requests (status, created_at, id);
+CREATE INDEX files_request_id_idx ON files (request_id);
+
+-- host is the lower-cased host name of url, filled in when the file is created.
+ALTER TABLE files ADD COLUMN host TEXT;
+UPDATE files SET host = lower(substring(url from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?(\[[^]]*\]|[^/?#:]*)'));
+UPDATE files SET host = trim(both '[]' from host) WHERE host LIKE '[%';
+CREATE INDEX files_host_idx ON files (host);
@@ -0,0 +1,3 @@
+-- How each blob is encoded at rest. Blobs stored so far are plain.
+ALTER TABLE content_blobs ADD COLUMN encoding TEXT NOT NULL DEFAULT 'identity';
+ALTER TABLE files ADD COLUMN encoding TEXT NOT NULL DEFAULT 'identity';
@@ -0,0 +1,6 @@
+-- Encrypted blobs cannot be read without their data keys; only revert a database without any.
+DROP INDEX IF EXISTS content_blobs_key_id_idx;
+ALTER TABLE files DROP COLUMN IF EXISTS wrapped_key;
+ALTER TABLE files DROP COLUMN IF EXISTS key_id;
+ALTER TABLE content_blobs DROP COLUMN IF EXISTS wrapped_key;
+ALTER TABLE content_blobs DROP COLUMN IF EXISTS key_id;
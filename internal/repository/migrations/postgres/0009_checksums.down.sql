@@ -0,0 +1,6 @@
+ALTER TABLE files DROP COLUMN IF EXISTS md5;
+ALTER TABLE files DROP COLUMN IF EXISTS sha512;
+ALTER TABLE files DROP COLUMN IF EXISTS sha256;
+ALTER TABLE files DROP COLUMN IF EXISTS expected_md5;
+ALTER TABLE files DROP COLUMN IF EXISTS expected_sha512;
+ALTER TABLE files DROP COLUMN IF EXISTS expected_sha256;
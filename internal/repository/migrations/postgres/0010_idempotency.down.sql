@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS requests_idempotency_key_idx;
+ALTER TABLE requests DROP COLUMN IF EXISTS fingerprint;
+ALTER TABLE requests DROP COLUMN IF EXISTS idempotency_key;
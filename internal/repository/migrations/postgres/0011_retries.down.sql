@@ -0,0 +1,2 @@
+ALTER TABLE requests DROP COLUMN IF EXISTS timeout_ms;
+ALTER TABLE files DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+ALTER TABLE requests DROP COLUMN IF EXISTS callback_url;
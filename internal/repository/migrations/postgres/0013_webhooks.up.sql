@@ -0,0 +1,15 @@
+-- Requests may name a URL that is told when they finish. Every attempt to deliver
+-- the notification is recorded.
+ALTER TABLE requests ADD COLUMN callback_url TEXT;
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    request_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    http_status INTEGER,
+    error_msg TEXT,
+    duration_ms BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX webhook_deliveries_request_id_idx ON webhook_deliveries (request_id, id);
@@ -0,0 +1,17 @@
+-- Baseline schema, matching version 1 of the Postgres schema. AUTOINCREMENT keeps
+-- ids of deleted rows from being handed out again.
+
+CREATE TABLE requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE files (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_id INTEGER REFERENCES requests(id),
+    url TEXT NOT NULL,
+    storage_key TEXT,
+    size BIGINT,
+    error_msg TEXT
+);
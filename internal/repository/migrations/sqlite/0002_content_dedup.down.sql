@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS files_content_hash_idx;
+
+-- SQLite cannot drop a column that takes part in a foreign key, so files is rebuilt without it.
+CREATE TABLE files_v1 (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_id INTEGER REFERENCES requests(id),
+    url TEXT NOT NULL,
+    storage_key TEXT,
+    size BIGINT,
+    error_msg TEXT
+);
+INSERT INTO files_v1 (id, request_id, url, storage_key, size, error_msg)
+SELECT id, request_id, url, storage_key, size, error_msg FROM files;
+DROP TABLE files;
+ALTER TABLE files_v1 RENAME TO files;
+
+DROP TABLE IF EXISTS content_blobs;
@@ -0,0 +1,6 @@
+ALTER TABLE files DROP COLUMN finished_at;
+ALTER TABLE files DROP COLUMN started_at;
+ALTER TABLE files DROP COLUMN final_url;
+ALTER TABLE files DROP COLUMN http_status;
+ALTER TABLE files DROP COLUMN file_name;
+ALTER TABLE files DROP COLUMN content_type;
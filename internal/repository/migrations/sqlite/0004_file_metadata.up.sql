@@ -0,0 +1,7 @@
+-- What the origin reported while a file was downloaded.
+ALTER TABLE files ADD COLUMN content_type TEXT;
+ALTER TABLE files ADD COLUMN file_name TEXT;
+ALTER TABLE files ADD COLUMN http_status INTEGER;
+ALTER TABLE files ADD COLUMN final_url TEXT;
+ALTER TABLE files ADD COLUMN started_at TIMESTAMP;
+ALTER TABLE files ADD COLUMN finished_at TIMESTAMP;
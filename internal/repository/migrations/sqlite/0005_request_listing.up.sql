@@ -0,0 +1,19 @@
+-- Indexes for listing requests with keyset pagination on (created_at, id),
+-- optionally narrowed down by status or by the host of one of their files.
+CREATE INDEX requests_created_at_idx ON requests (created_at, id);
+CREATE INDEX requests_status_created_at_idx ON requests (status, created_at, id);
+CREATE INDEX files_request_id_idx ON files (request_id);
+
+-- host is the lower-cased host name of url, filled in when the file is created.
+-- SQLite has no regular expressions, so existing rows are cut down step by step:
+-- drop the scheme, the path, query and fragment, the user info, and the port.
+ALTER TABLE files ADD COLUMN host TEXT;
+UPDATE files SET host = substr(url, instr(url, '://') + 3) WHERE instr(url, '://') > 0;
+UPDATE files SET host = substr(host, 1, instr(host, '/') - 1) WHERE instr(host, '/') > 0;
+UPDATE files SET host = substr(host, 1, instr(host, '?') - 1) WHERE instr(host, '?') > 0;
+UPDATE files SET host = substr(host, 1, instr(host, '#') - 1) WHERE instr(host, '#') > 0;
+UPDATE files SET host = substr(host, instr(host, '@') + 1) WHERE instr(host, '@') > 0;
+UPDATE files SET host = substr(host, 2, instr(host, ']') - 2) WHERE host LIKE '[%]%';
+UPDATE files SET host = substr(host, 1, instr(host, ':') - 1) WHERE instr(host, ':') > 0 AND host NOT LIKE '%:%:%';
+UPDATE files SET host = lower(host);
+CREATE INDEX files_host_idx ON files (host);
@@ -0,0 +1,2 @@
+UPDATE requests SET status = 'DONE' WHERE status IN ('PARTIAL', 'ERROR');
+ALTER TABLE files DROP COLUMN state;
@@ -0,0 +1,19 @@
+-- Files move through PENDING -> DOWNLOADING -> SUCCEEDED / FAILED, or end up
+-- SKIPPED / CANCELED without being attempted.
+ALTER TABLE files ADD COLUMN state TEXT NOT NULL DEFAULT 'PENDING';
+
+-- Existing files get the state their outcome implies; files of finished
+-- requests that never got an outcome were not attempted.
+UPDATE files SET state = 'SUCCEEDED'
+WHERE content_hash IS NOT NULL OR (storage_key IS NOT NULL AND COALESCE(error_msg, '') = '');
+UPDATE files SET state = 'FAILED' WHERE COALESCE(error_msg, '') <> '';
+UPDATE files SET state = 'SKIPPED'
+WHERE state = 'PENDING' AND request_id IN (SELECT id FROM requests WHERE status <> 'PROCESS');
+
+-- Finished requests were always DONE; derive the real status from their files.
+UPDATE requests SET status = CASE
+    WHEN NOT EXISTS (SELECT 1 FROM files WHERE files.request_id = requests.id AND files.state <> 'SUCCEEDED') THEN 'DONE'
+    WHEN NOT EXISTS (SELECT 1 FROM files WHERE files.request_id = requests.id AND files.state = 'SUCCEEDED') THEN 'ERROR'
+    ELSE 'PARTIAL'
+END
+WHERE status = 'DONE';
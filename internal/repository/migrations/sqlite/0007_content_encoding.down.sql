@@ -0,0 +1,3 @@
+-- Compressed blobs stay compressed; older binaries would serve them as they are stored.
+ALTER TABLE files DROP COLUMN encoding;
+ALTER TABLE content_blobs DROP COLUMN encoding;
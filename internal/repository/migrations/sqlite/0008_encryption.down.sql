@@ -0,0 +1,6 @@
+-- Encrypted blobs cannot be read without their data keys; only revert a database without any.
+DROP INDEX IF EXISTS content_blobs_key_id_idx;
+ALTER TABLE files DROP COLUMN wrapped_key;
+ALTER TABLE files DROP COLUMN key_id;
+ALTER TABLE content_blobs DROP COLUMN wrapped_key;
+ALTER TABLE content_blobs DROP COLUMN key_id;
@@ -0,0 +1,8 @@
+-- Data key of each encrypted blob, wrapped by the master key key_id.
+-- Blobs stored so far, and blobs stored without a key file, are plaintext (NULL).
+ALTER TABLE content_blobs ADD COLUMN key_id TEXT;
+ALTER TABLE content_blobs ADD COLUMN wrapped_key BLOB;
+ALTER TABLE files ADD COLUMN key_id TEXT;
+ALTER TABLE files ADD COLUMN wrapped_key BLOB;
+
+CREATE INDEX content_blobs_key_id_idx ON content_blobs (key_id);
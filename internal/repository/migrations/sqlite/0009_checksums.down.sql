@@ -0,0 +1,6 @@
+ALTER TABLE files DROP COLUMN md5;
+ALTER TABLE files DROP COLUMN sha512;
+ALTER TABLE files DROP COLUMN sha256;
+ALTER TABLE files DROP COLUMN expected_md5;
+ALTER TABLE files DROP COLUMN expected_sha512;
+ALTER TABLE files DROP COLUMN expected_sha256;
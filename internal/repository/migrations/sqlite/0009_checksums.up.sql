@@ -0,0 +1,10 @@
+-- Digests the client expects for each file, and the ones computed while downloading.
+ALTER TABLE files ADD COLUMN expected_sha256 TEXT;
+ALTER TABLE files ADD COLUMN expected_sha512 TEXT;
+ALTER TABLE files ADD COLUMN expected_md5 TEXT;
+ALTER TABLE files ADD COLUMN sha256 TEXT;
+ALTER TABLE files ADD COLUMN sha512 TEXT;
+ALTER TABLE files ADD COLUMN md5 TEXT;
+
+-- The content hash is the SHA-256 of every file downloaded so far.
+UPDATE files SET sha256 = content_hash WHERE content_hash IS NOT NULL;
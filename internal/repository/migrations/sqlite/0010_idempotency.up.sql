@@ -0,0 +1,5 @@
+-- Client-chosen keys that make request creation safe to retry, with a fingerprint
+-- of the body they were first used with.
+ALTER TABLE requests ADD COLUMN idempotency_key TEXT;
+ALTER TABLE requests ADD COLUMN fingerprint TEXT;
+CREATE UNIQUE INDEX requests_idempotency_key_idx ON requests (idempotency_key);
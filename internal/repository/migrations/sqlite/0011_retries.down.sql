@@ -0,0 +1,2 @@
+ALTER TABLE requests DROP COLUMN timeout_ms;
+ALTER TABLE files DROP COLUMN attempts;
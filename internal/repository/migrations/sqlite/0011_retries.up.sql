@@ -0,0 +1,5 @@
+-- Files can be downloaded again after a failure: attempts counts how often a file
+-- was started, and the request timeout is kept as the default for a retry.
+ALTER TABLE files ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+UPDATE files SET attempts = 1 WHERE started_at IS NOT NULL;
+ALTER TABLE requests ADD COLUMN timeout_ms BIGINT;
@@ -0,0 +1,16 @@
+-- Changes of requests, streamed to clients as server-sent events. An event is
+-- written in the transaction of the change it describes, and event ids double
+-- as SSE ids, so they are never handed out again.
+CREATE TABLE request_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    file_id INTEGER,
+    state TEXT,
+    error_code TEXT,
+    status TEXT,
+    bytes_downloaded BIGINT,
+    bytes_total BIGINT,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX request_events_request_id_idx ON request_events (request_id, id);
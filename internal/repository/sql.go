@@ -0,0 +1,967 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"async-file-storage/internal/domain"
+)
+
+// sqlRepository implements the repository on top of database/sql. Queries stick to
+// the subset of SQL that PostgreSQL and SQLite share, so both backends run the same code.
+// TIMESTAMP columns carry no time zone; times are always written in UTC.
+type sqlRepository struct {
+	db *sql.DB
+	// forUpdate locks the selected rows until the transaction ends. SQLite has no row
+	// locks and serializes write transactions instead, so it leaves this empty.
+	forUpdate string
+	// notify announces new events on EventsChannel with pg_notify.
+	notify bool
+}
+
+// EventsChannel is the PostgreSQL notification channel new request events are
+// announced on. The payload is the request id.
+const EventsChannel = "request_events"
+
+// creates a new download request and its file entries. A request with the same
+// idempotency key is returned instead, if there is one.
+func (r *sqlRepository) CreateRequest(ctx context.Context, newReq domain.NewRequest) (int, []domain.FileRef, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		// TODO: лучше commit и rollback обрабатывать здесь, если ошибки нет, то вызовется commit, иначе rollback
+		_ = tx.Rollback()
+	}()
+
+	// Concurrent inserts with the same key wait for each other; all but the first insert nothing.
+	var requestID int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO requests(status, created_at, expires_at, idempotency_key, fingerprint, timeout_ms, callback_url)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (idempotency_key) DO NOTHING RETURNING id`,
+		domain.StatusProcess, time.Now().UTC(), utcTime(newReq.ExpiresAt),
+		nullString(newReq.IdempotencyKey), nullString(newReq.Fingerprint),
+		sql.NullInt64{Int64: newReq.Timeout.Milliseconds(), Valid: newReq.Timeout > 0},
+		nullString(newReq.CallbackURL),
+	).Scan(&requestID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = tx.Rollback()
+		return r.idempotentRequest(ctx, newReq)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to insert: %w", err)
+	}
+
+	files, err := insertFiles(ctx, tx, requestID, newReq)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// можно перенести commit в defer
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return requestID, files, nil
+}
+
+// insertFiles adds the URLs of newReq to a request as PENDING files.
+func insertFiles(ctx context.Context, tx *sql.Tx, requestID int, newReq domain.NewRequest) ([]domain.FileRef, error) {
+	query := `INSERT INTO files (request_id, url, host, state, expected_sha256, expected_sha512, expected_md5)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	files := make([]domain.FileRef, 0, len(newReq.URLs))
+	for i, url := range newReq.URLs {
+		file := domain.FileRef{URL: url, Checksums: newReq.ChecksumsAt(i)}
+		err := tx.QueryRowContext(ctx, query, requestID, url, urlHost(url), domain.FilePending,
+			nullString(file.Checksums.SHA256), nullString(file.Checksums.SHA512), nullString(file.Checksums.MD5),
+		).Scan(&file.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert files: %w", err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// AppendFiles adds the URLs of files to a request in progress. A finished request yields
+// domain.ErrFinished, unless reopen is set: then it is moved back to PROCESS.
+// Only the URLs and checksums of files are used.
+func (r *sqlRepository) AppendFiles(ctx context.Context, requestID int, files domain.NewRequest, reopen bool) (refs []domain.FileRef, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var status domain.Status
+	err = tx.QueryRowContext(ctx, "SELECT status FROM requests WHERE id = $1"+r.forUpdate, requestID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = r.missingRequestError(ctx, requestID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != domain.StatusProcess {
+		if !reopen {
+			err = domain.ErrFinished
+			return nil, err
+		}
+		if _, err = tx.ExecContext(ctx, "UPDATE requests SET status = $1 WHERE id = $2", domain.StatusProcess, requestID); err != nil {
+			return nil, fmt.Errorf("failed to reopen request: %w", err)
+		}
+	}
+
+	if refs, err = insertFiles(ctx, tx, requestID, files); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// idempotentRequest returns the request created earlier with the idempotency key of newReq.
+func (r *sqlRepository) idempotentRequest(ctx context.Context, newReq domain.NewRequest) (int, []domain.FileRef, error) {
+	var requestID int
+	var fingerprint sql.NullString
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, fingerprint FROM requests WHERE idempotency_key = $1", newReq.IdempotencyKey,
+	).Scan(&requestID, &fingerprint)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to load request for idempotency key: %w", err)
+	}
+	if fingerprint.String != newReq.Fingerprint {
+		return 0, nil, domain.ErrIdempotencyMismatch
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, url, expected_sha256, expected_sha512, expected_md5 FROM files WHERE request_id = $1 ORDER BY id",
+		requestID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var files []domain.FileRef
+	for rows.Next() {
+		var f domain.FileRef
+		var sha256, sha512, md5 sql.NullString
+		if err := rows.Scan(&f.ID, &f.URL, &sha256, &sha512, &md5); err != nil {
+			return 0, nil, err
+		}
+		f.Checksums = domain.Digests{SHA256: sha256.String, SHA512: sha512.String, MD5: md5.String}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return requestID, files, nil
+}
+
+// UpdateRequestStatus changes the status of a specific request. A final status
+// records a request.completed event.
+func (r *sqlRepository) UpdateRequestStatus(ctx context.Context, id int, status domain.Status) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE requests SET status = $1 WHERE id = $2",
+		status, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 || status == domain.StatusProcess {
+		return tx.Commit()
+	}
+	err = r.recordEvent(ctx, tx, domain.Event{RequestID: id, Type: domain.EventRequestCompleted, Status: status})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateFileStatus moves a file to a new state, recording its download error and metadata
+// and the event of the new state. Every move to DOWNLOADING counts as an attempt.
+func (r *sqlRepository) UpdateFileStatus(ctx context.Context, requestID, fileID int, state domain.FileState, meta domain.FileMeta, downloadErr error) error {
+	var errMsg string
+	if downloadErr != nil {
+		errMsg = downloadErr.Error()
+	}
+	attempt := 0
+	if state == domain.FileDownloading {
+		attempt = 1
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.lockRequest(ctx, tx, requestID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	res, err := tx.ExecContext(ctx,
+		`UPDATE files SET state = $1, error_msg = $2, content_type = $3, file_name = $4, http_status = $5, final_url = $6,
+		 started_at = $7, finished_at = $8, sha256 = $9, sha512 = $10, md5 = $11, attempts = attempts + $12
+		 WHERE id = $13 AND request_id = $14`,
+		state, errMsg, nullString(meta.ContentType), nullString(meta.FileName), nullInt(meta.HTTPStatus), nullString(meta.FinalURL),
+		utcTime(meta.StartedAt), utcTime(meta.FinishedAt),
+		nullString(meta.Digests.SHA256), nullString(meta.Digests.SHA512), nullString(meta.Digests.MD5), attempt, fileID, requestID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if typ, ok := domain.FileEvent(state); ok {
+		err = r.recordEvent(ctx, tx, domain.Event{RequestID: requestID, Type: typ, FileID: fileID, State: state, Error: errMsg})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RecordProgress records a file.progress event for every file of progress that is
+// still DOWNLOADING.
+func (r *sqlRepository) RecordProgress(ctx context.Context, requestID int, progress []domain.FileProgress) error {
+	if len(progress) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.lockRequest(ctx, tx, requestID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	for _, p := range progress {
+		var state domain.FileState
+		err := tx.QueryRowContext(ctx,
+			"SELECT state FROM files WHERE id = $1 AND request_id = $2", p.FileID, requestID,
+		).Scan(&state)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Progress reported after the file finished would arrive behind its final event.
+		if state != domain.FileDownloading {
+			continue
+		}
+		err = r.recordEvent(ctx, tx, domain.Event{
+			RequestID: requestID, Type: domain.EventFileProgress, FileID: p.FileID,
+			BytesDownloaded: p.BytesDownloaded, BytesTotal: p.BytesTotal,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockRequest locks the request row until the transaction ends. Every transaction that
+// records events takes this lock first, so the events of a request commit in id order
+// and a client reading past an event id never misses one committed later.
+func (r *sqlRepository) lockRequest(ctx context.Context, tx *sql.Tx, id int) error {
+	err := tx.QueryRowContext(ctx, "SELECT id FROM requests WHERE id = $1"+r.forUpdate, id).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrNotFound
+	}
+	return err
+}
+
+// recordEvent appends an event to the stream of its request in the caller's transaction,
+// which holds the lock of the request. Only the latest progress of a file is kept:
+// any later event of the file supersedes it.
+func (r *sqlRepository) recordEvent(ctx context.Context, tx *sql.Tx, e domain.Event) error {
+	if e.FileID != 0 {
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM request_events WHERE request_id = $1 AND file_id = $2 AND type = $3",
+			e.RequestID, e.FileID, domain.EventFileProgress)
+		if err != nil {
+			return fmt.Errorf("failed to delete progress events: %w", err)
+		}
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO request_events (request_id, type, file_id, state, error_code, status, bytes_downloaded, bytes_total, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.RequestID, e.Type, nullInt(e.FileID), nullString(string(e.State)), nullString(e.Error), nullString(string(e.Status)),
+		e.BytesDownloaded, e.BytesTotal, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert event: %w", err)
+	}
+	if r.notify {
+		// Notifications are delivered on commit, and only once per payload and transaction.
+		if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", EventsChannel, strconv.Itoa(e.RequestID)); err != nil {
+			return fmt.Errorf("failed to notify: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListEvents returns up to limit events of a request with an id above afterID, in id order.
+func (r *sqlRepository) ListEvents(ctx context.Context, requestID int, afterID int64, limit int) ([]domain.Event, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, request_id, type, file_id, state, error_code, status, bytes_downloaded, bytes_total, created_at
+		 FROM request_events WHERE request_id = $1 AND id > $2 ORDER BY id LIMIT $3`,
+		requestID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var events []domain.Event
+	for rows.Next() {
+		var e domain.Event
+		var fileID, downloaded, total sql.NullInt64
+		var state, errCode, status sql.NullString
+		err := rows.Scan(&e.ID, &e.RequestID, &e.Type, &fileID, &state, &errCode, &status, &downloaded, &total, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.FileID = int(fileID.Int64)
+		e.State = domain.FileState(state.String)
+		e.Error = errCode.String
+		e.Status = domain.Status(status.String)
+		e.BytesDownloaded = downloaded.Int64
+		e.BytesTotal = total.Int64
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ListProgress returns the progress last recorded for the files of a request, ordered by
+// file id. Any later event of a file drops it, so only downloading files have any.
+func (r *sqlRepository) ListProgress(ctx context.Context, requestID int) ([]domain.FileProgress, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT file_id, bytes_downloaded, bytes_total FROM request_events
+		 WHERE request_id = $1 AND type = $2 ORDER BY file_id`,
+		requestID, domain.EventFileProgress)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var progress []domain.FileProgress
+	for rows.Next() {
+		var p domain.FileProgress
+		if err := rows.Scan(&p.FileID, &p.BytesDownloaded, &p.BytesTotal); err != nil {
+			return nil, err
+		}
+		progress = append(progress, p)
+	}
+	return progress, rows.Err()
+}
+
+// RetryFiles puts failed files of a finished request back to PENDING, clearing what
+// their last attempt recorded, and the request back to PROCESS. It returns
+// domain.ErrInProgress if the request has not finished, and domain.ErrNotFound if
+// one of the files does not exist or has not failed; nothing changes then.
+func (r *sqlRepository) RetryFiles(ctx context.Context, requestID int, fileIDs []int) (refs []domain.FileRef, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Claiming the request first makes concurrent retries wait for each other.
+	res, err := tx.ExecContext(ctx,
+		"UPDATE requests SET status = $1 WHERE id = $2 AND status <> $3",
+		domain.StatusProcess, requestID, domain.StatusProcess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update request: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM requests WHERE id = $1)", requestID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			err = domain.ErrInProgress
+		} else {
+			err = r.missingRequestError(ctx, requestID)
+		}
+		return nil, err
+	}
+
+	for _, id := range fileIDs {
+		ref := domain.FileRef{ID: id}
+		var sha256, sha512, md5 sql.NullString
+		err = tx.QueryRowContext(ctx,
+			`UPDATE files SET state = $1, error_msg = NULL, content_type = NULL, file_name = NULL, http_status = NULL,
+			 final_url = NULL, started_at = NULL, finished_at = NULL, sha256 = NULL, sha512 = NULL, md5 = NULL
+			 WHERE id = $2 AND request_id = $3 AND state IN ($4, $5, $6)
+			 RETURNING url, expected_sha256, expected_sha512, expected_md5`,
+			domain.FilePending, id, requestID, domain.FileFailed, domain.FileSkipped, domain.FileCanceled,
+		).Scan(&ref.URL, &sha256, &sha512, &md5)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("file %d: %w", id, domain.ErrNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ref.Checksums = domain.Digests{SHA256: sha256.String, SHA512: sha512.String, MD5: md5.String}
+		refs = append(refs, ref)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// RecordDelivery stores an attempt to deliver the webhook of a request.
+func (r *sqlRepository) RecordDelivery(ctx context.Context, d domain.WebhookDelivery) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (request_id, url, attempt, status, http_status, error_msg, duration_ms, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		d.RequestID, d.URL, d.Attempt, d.Status, nullInt(d.HTTPStatus), nullString(d.Error),
+		d.Duration.Milliseconds(), time.Now().UTC())
+	return err
+}
+
+// ListDeliveries returns the webhook delivery attempts of a request, oldest first.
+func (r *sqlRepository) ListDeliveries(ctx context.Context, requestID int) ([]domain.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, request_id, url, attempt, status, http_status, error_msg, duration_ms, created_at
+		 FROM webhook_deliveries WHERE request_id = $1 ORDER BY id`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		var httpStatus sql.NullInt64
+		var errMsg sql.NullString
+		var durationMS int64
+		err := rows.Scan(&d.ID, &d.RequestID, &d.URL, &d.Attempt, &d.Status, &httpStatus, &errMsg, &durationMS, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.HTTPStatus = int(httpStatus.Int64)
+		d.Error = errMsg.String
+		d.Duration = time.Duration(durationMS) * time.Millisecond
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// SaveFileContent points the file at a content blob, creating the blob record or
+// taking another reference on an existing one with the same hash.
+func (r *sqlRepository) SaveFileContent(ctx context.Context, requestID, fileID int, content domain.StoredContent, meta domain.FileMeta) (key string, orphaned []string, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = r.lockRequest(ctx, tx, requestID); err != nil {
+		return "", nil, err
+	}
+	var previous sql.NullString
+	err = tx.QueryRowContext(ctx,
+		"SELECT content_hash FROM files WHERE id = $1 AND request_id = $2"+r.forUpdate,
+		fileID, requestID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = domain.ErrNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	// A shared blob keeps the encoding and data key it was first stored with.
+	var encoding domain.ContentEncoding
+	var keyID sql.NullString
+	var wrapped []byte
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO content_blobs (hash, storage_key, size, encoding, key_id, wrapped_key, ref_count, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, 1, $7)
+		 ON CONFLICT (hash) DO UPDATE SET ref_count = content_blobs.ref_count + 1
+		 RETURNING storage_key, encoding, key_id, wrapped_key`,
+		content.Hash, content.StorageKey, content.Size, encodingOrIdentity(content.Encoding),
+		nullString(content.DataKey.MasterKeyID), nullBytes(content.DataKey.Wrapped), time.Now().UTC(),
+	).Scan(&key, &encoding, &keyID, &wrapped)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upsert content blob: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE files SET content_hash = $1, storage_key = $2, size = $3, encoding = $4, key_id = $5, wrapped_key = $6,
+		 state = $7, error_msg = NULL, content_type = $8, file_name = $9, http_status = $10, final_url = $11,
+		 started_at = $12, finished_at = $13, sha256 = $14, sha512 = $15, md5 = $16
+		 WHERE id = $17`,
+		content.Hash, key, content.Size, encoding, keyID, nullBytes(wrapped), domain.FileSucceeded,
+		nullString(meta.ContentType), nullString(meta.FileName), nullInt(meta.HTTPStatus), nullString(meta.FinalURL),
+		utcTime(meta.StartedAt), utcTime(meta.FinishedAt),
+		nullString(meta.Digests.SHA256), nullString(meta.Digests.SHA512), nullString(meta.Digests.MD5), fileID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to update file: %w", err)
+	}
+	err = r.recordEvent(ctx, tx, domain.Event{
+		RequestID: requestID, Type: domain.EventFileSucceeded, FileID: fileID, State: domain.FileSucceeded,
+		BytesDownloaded: content.Size, BytesTotal: content.Size,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if previous.Valid {
+		if orphaned, err = releaseContent(ctx, tx, previous.String); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	return key, orphaned, nil
+}
+
+// DeleteRequest removes the request and its files. It returns the storage keys
+// of blobs that are no longer referenced by any file.
+func (r *sqlRepository) DeleteRequest(ctx context.Context, id int) ([]string, error) {
+	return r.deleteRequest(ctx, id, false)
+}
+
+// PurgeRequest removes an expired request and records a tombstone for it.
+func (r *sqlRepository) PurgeRequest(ctx context.Context, id int) ([]string, error) {
+	return r.deleteRequest(ctx, id, true)
+}
+
+// ListExpiredRequests returns ids of finished requests whose retention has ended.
+func (r *sqlRepository) ListExpiredRequests(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id FROM requests WHERE expires_at <= $1 AND status <> $2 ORDER BY expires_at LIMIT $3",
+		now.UTC(), domain.StatusProcess, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *sqlRepository) deleteRequest(ctx context.Context, id int, tombstone bool) (orphaned []string, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Lock the request before its files, in the order the writers of events do.
+	if err = r.lockRequest(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM request_events WHERE request_id = $1", id); err != nil {
+		return nil, fmt.Errorf("failed to delete events: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE request_id = $1", id); err != nil {
+		return nil, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"DELETE FROM files WHERE request_id = $1 RETURNING content_hash, storage_key", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete files: %w", err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash, key sql.NullString
+		if err = rows.Scan(&hash, &key); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		switch {
+		case hash.Valid:
+			hashes = append(hashes, hash.String)
+		case key.Valid:
+			// Content stored before deduplication is owned by this file alone.
+			orphaned = append(orphaned, key.String)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		var freed []string
+		if freed, err = releaseContent(ctx, tx, hash); err != nil {
+			return nil, err
+		}
+		orphaned = append(orphaned, freed...)
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM requests WHERE id = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete request: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = domain.ErrNotFound
+		return nil, err
+	}
+
+	if tombstone {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO request_tombstones (id, purged_at) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING",
+			id, time.Now().UTC())
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert tombstone: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return orphaned, nil
+}
+
+// ListStaleDataKeys returns encrypted blobs whose data key is wrapped by another master key than activeKeyID.
+func (r *sqlRepository) ListStaleDataKeys(ctx context.Context, activeKeyID string, limit int) ([]domain.BlobKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT hash, key_id, wrapped_key FROM content_blobs
+		 WHERE key_id IS NOT NULL AND key_id <> $1 ORDER BY hash LIMIT $2`,
+		activeKeyID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var blobs []domain.BlobKey
+	for rows.Next() {
+		var b domain.BlobKey
+		if err := rows.Scan(&b.Hash, &b.Key.MasterKeyID, &b.Key.Wrapped); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// ReplaceDataKey swaps the data key of a blob and of the files that share it. It does
+// nothing when the blob is gone or its key is no longer old.
+func (r *sqlRepository) ReplaceDataKey(ctx context.Context, hash string, old, replacement domain.DataKey) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE content_blobs SET key_id = $1, wrapped_key = $2 WHERE hash = $3 AND key_id = $4 AND wrapped_key = $5",
+		replacement.MasterKeyID, replacement.Wrapped, hash, old.MasterKeyID, old.Wrapped)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return tx.Commit()
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE files SET key_id = $1, wrapped_key = $2 WHERE content_hash = $3",
+		replacement.MasterKeyID, replacement.Wrapped, hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// releaseContent drops one reference to a content blob. The record is removed with
+// its last reference, inside the caller's transaction, so a concurrent download of
+// the same content creates a fresh blob instead of reusing one about to be deleted.
+func releaseContent(ctx context.Context, tx *sql.Tx, hash string) ([]string, error) {
+	_, err := tx.ExecContext(ctx, "UPDATE content_blobs SET ref_count = ref_count - 1 WHERE hash = $1", hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release content blob: %w", err)
+	}
+
+	var key string
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM content_blobs WHERE hash = $1 AND ref_count <= 0 RETURNING storage_key", hash,
+	).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete content blob: %w", err)
+	}
+	return []string{key}, nil
+}
+
+// GetRequest returns the request without its files. Purged requests yield domain.ErrGone.
+func (r *sqlRepository) GetRequest(ctx context.Context, id int) (*domain.DownloadRequest, error) {
+	req := &domain.DownloadRequest{}
+	var expiresAt sql.NullTime
+	var idempotencyKey, callbackURL sql.NullString
+	var timeoutMS sql.NullInt64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, status, created_at, expires_at, idempotency_key, timeout_ms, callback_url FROM requests WHERE id = $1", id,
+	).Scan(&req.ID, &req.Status, &req.CreatedAt, &expiresAt, &idempotencyKey, &timeoutMS, &callbackURL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, r.missingRequestError(ctx, id)
+		}
+		return nil, err
+	}
+	if expiresAt.Valid {
+		req.ExpiresAt = &expiresAt.Time
+	}
+	req.IdempotencyKey = idempotencyKey.String
+	req.Timeout = time.Duration(timeoutMS.Int64) * time.Millisecond
+	req.CallbackURL = callbackURL.String
+	return req, nil
+}
+
+// ListRequests returns one page of requests in (created_at, id) order.
+func (r *sqlRepository) ListRequests(ctx context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	f := query.Filter
+	if f.Status != "" {
+		where = append(where, "status = "+arg(f.Status))
+	}
+	if f.CreatedFrom != nil {
+		where = append(where, "created_at >= "+arg(f.CreatedFrom.UTC()))
+	}
+	if f.CreatedTo != nil {
+		where = append(where, "created_at < "+arg(f.CreatedTo.UTC()))
+	}
+	if f.ActiveAt != nil {
+		where = append(where, "(expires_at IS NULL OR expires_at > "+arg(f.ActiveAt.UTC())+" OR status = "+arg(domain.StatusProcess)+")")
+	}
+	if f.URLContains != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM files WHERE files.request_id = requests.id AND lower(files.url) LIKE "+
+			arg(containsPattern(f.URLContains))+` ESCAPE '\')`)
+	}
+	if f.Host != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM files WHERE files.request_id = requests.id AND files.host = "+
+			arg(strings.ToLower(f.Host))+")")
+	}
+
+	cmp, order := ">", "ASC"
+	if query.Descending {
+		cmp, order = "<", "DESC"
+	}
+	if query.After != nil {
+		where = append(where, "(created_at, id) "+cmp+" ("+arg(query.After.CreatedAt.UTC())+", "+arg(query.After.ID)+")")
+	}
+
+	sqlQuery := "SELECT id, status, created_at, expires_at FROM requests"
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlQuery += " ORDER BY created_at " + order + ", id " + order + " LIMIT " + arg(query.Limit)
+
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var requests []domain.DownloadRequest
+	for rows.Next() {
+		var req domain.DownloadRequest
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&req.ID, &req.Status, &req.CreatedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			req.ExpiresAt = &expiresAt.Time
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// missingRequestError tells a purged request apart from one that never existed.
+func (r *sqlRepository) missingRequestError(ctx context.Context, id int) error {
+	var purged bool
+	err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM request_tombstones WHERE id = $1)", id,
+	).Scan(&purged)
+	if err != nil {
+		return err
+	}
+	if purged {
+		return domain.ErrGone
+	}
+	return domain.ErrNotFound
+}
+
+// GetRequestStatus returns the request and the metadata of its files, without content.
+func (r *sqlRepository) GetRequestStatus(ctx context.Context, id int) (*domain.DownloadRequest, []domain.FileEntry, error) {
+	req, err := r.GetRequest(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE request_id = $1 ORDER BY id", id,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var files []domain.FileEntry
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, *f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return req, files, nil
+}
+
+// GetFile returns a file by request and file id.
+func (r *sqlRepository) GetFile(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
+	f, err := scanFile(r.db.QueryRowContext(ctx,
+		"SELECT "+fileColumns+" FROM files WHERE request_id = $1 AND id = $2",
+		requestID, fileID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// fileColumns is the column list scanFile expects.
+const fileColumns = `id, request_id, url, storage_key, size, content_hash, encoding, key_id, wrapped_key, state, error_msg,
+	content_type, file_name, http_status, final_url, started_at, finished_at,
+	expected_sha256, expected_sha512, expected_md5, sha256, sha512, md5, attempts`
+
+func scanFile(row interface{ Scan(...any) error }) (*domain.FileEntry, error) {
+	var f domain.FileEntry
+	var key, hash, keyID, dbErr, contentType, fileName, finalURL sql.NullString
+	var expected, digests [3]sql.NullString
+	var size sql.NullInt64
+	var httpStatus sql.NullInt32
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(&f.ID, &f.RequestID, &f.URL, &key, &size, &hash, &f.Encoding, &keyID, &f.DataKey.Wrapped, &f.State, &dbErr,
+		&contentType, &fileName, &httpStatus, &finalURL, &startedAt, &finishedAt,
+		&expected[0], &expected[1], &expected[2], &digests[0], &digests[1], &digests[2], &f.Attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	f.StorageKey = key.String
+	f.Size = size.Int64
+	f.ContentHash = hash.String
+	f.DataKey.MasterKeyID = keyID.String
+	f.Error = dbErr.String
+	f.ContentType = contentType.String
+	f.FileName = fileName.String
+	f.HTTPStatus = int(httpStatus.Int32)
+	f.FinalURL = finalURL.String
+	if startedAt.Valid {
+		f.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		f.FinishedAt = &finishedAt.Time
+	}
+	f.Checksums = domain.Digests{SHA256: expected[0].String, SHA512: expected[1].String, MD5: expected[2].String}
+	f.Digests = domain.Digests{SHA256: digests[0].String, SHA512: digests[1].String, MD5: digests[2].String}
+	return &f, nil
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullBytes stores an empty slice as NULL.
+func nullBytes(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// encodingOrIdentity treats an unset encoding as plain content.
+func encodingOrIdentity(enc domain.ContentEncoding) domain.ContentEncoding {
+	if enc == "" {
+		return domain.EncodingIdentity
+	}
+	return enc
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqlitePragmas are applied to every connection. WAL lets readers run next to the
+// single writer, busy_timeout makes a writer wait for the lock instead of failing,
+// and _txlock=immediate takes the write lock when a transaction begins, so two
+// transactions never deadlock upgrading from a read lock.
+const sqlitePragmas = "_pragma=journal_mode(WAL)" +
+	"&_pragma=busy_timeout(10000)" +
+	"&_pragma=foreign_keys(1)" +
+	"&_pragma=synchronous(NORMAL)" +
+	"&_txlock=immediate" +
+	"&_time_format=sqlite"
+
+// SQLiteRepository keeps the metadata in an embedded SQLite database file,
+// for single-node deployments that do not want to operate Postgres.
+type SQLiteRepository struct {
+	sqlRepository
+}
+
+// NewSQLiteRepository opens the database file and checks that its schema
+// version matches the embedded migrations. It never changes the schema itself.
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteRepository{sqlRepository{db: db}}, nil
+}
+
+// OpenSQLite opens the database file, creating it and its directory if needed.
+func OpenSQLite(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create db dir: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?"+sqlitePragmas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping db: %w", err)
+	}
+	return db, nil
+}
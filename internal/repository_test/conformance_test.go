@@ -0,0 +1,912 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
+	"async-file-storage/internal/usecase"
+)
+
+// repo is the surface every repository implementation has to provide.
+type repo interface {
+	domain.Storage
+	usecase.Repository
+	envelope.KeyStore
+}
+
+// runConformance checks the behaviour shared by all repository implementations.
+// newRepo must return an empty repository, or at least one whose ids do not matter.
+func runConformance(t *testing.T, newRepo func(t *testing.T) repo) {
+	t.Run("CreateAndGet", func(t *testing.T) { testCreateAndGet(t, newRepo(t)) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newRepo(t)) })
+	t.Run("FileOutcomes", func(t *testing.T) { testFileOutcomes(t, newRepo(t)) })
+	t.Run("DuplicateURLs", func(t *testing.T) { testDuplicateURLs(t, newRepo(t)) })
+	t.Run("Idempotency", func(t *testing.T) { testIdempotency(t, newRepo(t)) })
+	t.Run("RetryFiles", func(t *testing.T) { testRetryFiles(t, newRepo(t)) })
+	t.Run("AppendFiles", func(t *testing.T) { testAppendFiles(t, newRepo(t)) })
+	t.Run("Events", func(t *testing.T) { testEvents(t, newRepo(t)) })
+	t.Run("WebhookDeliveries", func(t *testing.T) { testWebhookDeliveries(t, newRepo(t)) })
+	t.Run("SharedContent", func(t *testing.T) { testSharedContent(t, newRepo(t)) })
+	t.Run("ReplacedContent", func(t *testing.T) { testReplacedContent(t, newRepo(t)) })
+	t.Run("DataKeys", func(t *testing.T) { testDataKeys(t, newRepo(t)) })
+	t.Run("Retention", func(t *testing.T) { testRetention(t, newRepo(t)) })
+	t.Run("ListRequests", func(t *testing.T) { testListRequests(t, newRepo(t)) })
+	t.Run("ConcurrentCreate", func(t *testing.T) { testConcurrentCreate(t, newRepo(t)) })
+	t.Run("ConcurrentSave", func(t *testing.T) { testConcurrentSave(t, newRepo(t)) })
+}
+
+func mustCreate(t *testing.T, r repo, req domain.NewRequest) int {
+	t.Helper()
+	id, _ := mustCreateFiles(t, r, req)
+	return id
+}
+
+func mustCreateFiles(t *testing.T, r repo, req domain.NewRequest) (int, []domain.FileRef) {
+	t.Helper()
+	id, files, err := r.CreateRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+	if len(files) != len(req.URLs) {
+		t.Fatalf("expected %d files, got %v", len(req.URLs), files)
+	}
+	for i, f := range files {
+		if f.ID <= 0 || f.URL != req.URLs[i] {
+			t.Fatalf("unexpected file %+v for %s", f, req.URLs[i])
+		}
+	}
+	return id, files
+}
+
+func mustFiles(t *testing.T, r repo, id int) []domain.FileEntry {
+	t.Helper()
+	_, files, err := r.GetRequestStatus(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get request status: %v", err)
+	}
+	return files
+}
+
+func testCreateAndGet(t *testing.T, r repo) {
+	ctx := context.Background()
+	expiresAt := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	urls := []string{"https://example.com/a", "https://example.com/b"}
+
+	id := mustCreate(t, r, domain.NewRequest{URLs: urls, ExpiresAt: &expiresAt})
+
+	req, err := r.GetRequest(ctx, id)
+	if err != nil {
+		t.Fatalf("get request: %v", err)
+	}
+	if req.ID != id || req.Status != domain.StatusProcess {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if req.ExpiresAt == nil || !req.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected expiry %v, got %v", expiresAt, req.ExpiresAt)
+	}
+
+	if err := r.UpdateRequestStatus(ctx, id, domain.StatusDone); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	req, files, err := r.GetRequestStatus(ctx, id)
+	if err != nil {
+		t.Fatalf("get request status: %v", err)
+	}
+	if req.Status != domain.StatusDone {
+		t.Fatalf("expected DONE, got %s", req.Status)
+	}
+	var got []string
+	for _, f := range files {
+		if f.RequestID != id || f.ID <= 0 || f.State != domain.FilePending || f.Encoding != domain.EncodingIdentity {
+			t.Fatalf("unexpected file %+v", f)
+		}
+		got = append(got, f.URL)
+	}
+	if !reflect.DeepEqual(got, urls) {
+		t.Fatalf("expected files %v in order, got %v", urls, got)
+	}
+}
+
+func testNotFound(t *testing.T, r repo) {
+	ctx := context.Background()
+	id := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}})
+	missing := id + 1000
+
+	if _, err := r.GetRequest(ctx, missing); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetRequest: expected ErrNotFound, got %v", err)
+	}
+	if _, _, err := r.GetRequestStatus(ctx, missing); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetRequestStatus: expected ErrNotFound, got %v", err)
+	}
+	if _, err := r.GetFile(ctx, missing, 1); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetFile: expected ErrNotFound, got %v", err)
+	}
+	fileID := mustFiles(t, r, id)[0].ID
+	if _, err := r.GetFile(ctx, missing, fileID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetFile of another request: expected ErrNotFound, got %v", err)
+	}
+	if _, err := r.DeleteRequest(ctx, missing); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("DeleteRequest: expected ErrNotFound, got %v", err)
+	}
+	content := domain.StoredContent{Hash: "h", StorageKey: "content/h", Size: 1}
+	if _, _, err := r.SaveFileContent(ctx, id, fileID+1000, content, domain.FileMeta{}); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("SaveFileContent: expected ErrNotFound, got %v", err)
+	}
+	if _, _, err := r.SaveFileContent(ctx, missing, fileID, content, domain.FileMeta{}); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("SaveFileContent of another request: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testFileOutcomes(t *testing.T, r repo) {
+	ctx := context.Background()
+	expected := domain.Digests{SHA256: "hash-ok", MD5: "md5-ok"}
+	id, refs := mustCreateFiles(t, r, domain.NewRequest{
+		URLs:      []string{"https://example.com/ok", "https://example.com/bad"},
+		Checksums: []domain.Digests{expected},
+	})
+	if refs[0].Checksums != expected || refs[1].Checksums != (domain.Digests{}) {
+		t.Fatalf("expected the checksums on the file refs, got %+v", refs)
+	}
+
+	started := time.Now().UTC().Truncate(time.Millisecond)
+	finished := started.Add(time.Second)
+	failedMeta := domain.FileMeta{HTTPStatus: 404, FinalURL: "https://example.com/bad", StartedAt: &started, FinishedAt: &finished,
+		Digests: domain.Digests{SHA256: "hash-bad"}}
+	if err := r.UpdateFileStatus(ctx, id, refs[1].ID, domain.FileFailed, failedMeta, errors.New("DOWNLOAD_FAILED")); err != nil {
+		t.Fatalf("update file status: %v", err)
+	}
+	meta := domain.FileMeta{
+		ContentType: "application/pdf",
+		FileName:    "report.pdf",
+		HTTPStatus:  200,
+		FinalURL:    "https://cdn.example.com/ok",
+		StartedAt:   &started,
+		FinishedAt:  &finished,
+		Digests:     domain.Digests{SHA256: "hash-ok", MD5: "md5-ok"},
+	}
+	content := domain.StoredContent{Hash: "hash-ok", StorageKey: "content/ok", Size: 42}
+	key, orphaned, err := r.SaveFileContent(ctx, id, refs[0].ID, content, meta)
+	if err != nil {
+		t.Fatalf("save content: %v", err)
+	}
+	if key != content.StorageKey || len(orphaned) != 0 {
+		t.Fatalf("expected key %q and no orphans, got %q %v", content.StorageKey, key, orphaned)
+	}
+
+	files := mustFiles(t, r, id)
+	if files[0].State != domain.FileSucceeded || files[0].Error != "" || files[0].ContentHash != "hash-ok" || files[0].Size != 42 {
+		t.Fatalf("unexpected stored file %+v", files[0])
+	}
+	if files[1].State != domain.FileFailed || files[1].Error != "DOWNLOAD_FAILED" || files[1].HTTPStatus != 404 || files[1].ContentType != "" {
+		t.Fatalf("unexpected failed file %+v", files[1])
+	}
+	assertMeta(t, files[0].FileMeta, meta)
+	assertMeta(t, files[1].FileMeta, failedMeta)
+	if files[0].Checksums != expected {
+		t.Fatalf("expected checksums %+v, got %+v", expected, files[0].Checksums)
+	}
+
+	f, err := r.GetFile(ctx, id, files[0].ID)
+	if err != nil {
+		t.Fatalf("get file: %v", err)
+	}
+	if f.StorageKey != "content/ok" || f.Size != 42 || f.URL != "https://example.com/ok" {
+		t.Fatalf("unexpected file %+v", f)
+	}
+	assertMeta(t, f.FileMeta, meta)
+}
+
+func assertMeta(t *testing.T, got, want domain.FileMeta) {
+	t.Helper()
+	if got.StartedAt == nil || !got.StartedAt.Equal(*want.StartedAt) ||
+		got.FinishedAt == nil || !got.FinishedAt.Equal(*want.FinishedAt) {
+		t.Fatalf("expected timings %v - %v, got %v - %v", want.StartedAt, want.FinishedAt, got.StartedAt, got.FinishedAt)
+	}
+	got.StartedAt, got.FinishedAt = want.StartedAt, want.FinishedAt
+	if got != want {
+		t.Fatalf("expected metadata %+v, got %+v", want, got)
+	}
+}
+
+// testDuplicateURLs checks that a URL listed twice yields two independent files.
+func testDuplicateURLs(t *testing.T, r repo) {
+	ctx := context.Background()
+	url := "https://example.com/a"
+	id, refs := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{url, url}})
+	if refs[0].ID == refs[1].ID {
+		t.Fatalf("expected distinct file ids, got %v", refs)
+	}
+
+	if err := r.UpdateFileStatus(ctx, id, refs[0].ID, domain.FileFailed, domain.FileMeta{HTTPStatus: 503}, errors.New("DOWNLOAD_FAILED")); err != nil {
+		t.Fatalf("update file status: %v", err)
+	}
+	content := domain.StoredContent{Hash: "hash-a", StorageKey: "content/a", Size: 7}
+	if _, _, err := r.SaveFileContent(ctx, id, refs[1].ID, content, domain.FileMeta{HTTPStatus: 200}); err != nil {
+		t.Fatalf("save content: %v", err)
+	}
+
+	files := mustFiles(t, r, id)
+	if files[0].ID != refs[0].ID || files[0].State != domain.FileFailed || files[0].Error != "DOWNLOAD_FAILED" ||
+		files[0].HTTPStatus != 503 || files[0].ContentHash != "" {
+		t.Fatalf("unexpected failed file %+v", files[0])
+	}
+	if files[1].ID != refs[1].ID || files[1].State != domain.FileSucceeded || files[1].Error != "" ||
+		files[1].HTTPStatus != 200 || files[1].ContentHash != "hash-a" {
+		t.Fatalf("unexpected stored file %+v", files[1])
+	}
+
+	// The blob is referenced once, so deleting the request frees it.
+	orphaned, err := r.DeleteRequest(ctx, id)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if !reflect.DeepEqual(orphaned, []string{"content/a"}) {
+		t.Fatalf("expected the content to be orphaned, got %v", orphaned)
+	}
+}
+
+func testIdempotency(t *testing.T, r repo) {
+	ctx := context.Background()
+	req := domain.NewRequest{
+		URLs:           []string{"https://example.com/a", "https://example.com/b"},
+		Checksums:      []domain.Digests{{}, {MD5: "md5-b"}},
+		IdempotencyKey: "key-1",
+		Fingerprint:    "body-1",
+	}
+
+	// Concurrent retries all end up with the request created first.
+	const attempts = 5
+	ids := make([]int, attempts)
+	refs := make([][]domain.FileRef, attempts)
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], refs[i], errs[i] = r.CreateRequest(ctx, req)
+		}()
+	}
+	wg.Wait()
+	for i := range attempts {
+		if errs[i] != nil {
+			t.Fatalf("attempt %d: %v", i, errs[i])
+		}
+		if ids[i] != ids[0] || !reflect.DeepEqual(refs[i], refs[0]) {
+			t.Fatalf("expected every attempt to return request %d %v, got %d %v", ids[0], refs[0], ids[i], refs[i])
+		}
+	}
+	if len(refs[0]) != 2 || refs[0][1].Checksums.MD5 != "md5-b" {
+		t.Fatalf("unexpected files %+v", refs[0])
+	}
+
+	changed := req
+	changed.Fingerprint = "body-2"
+	if _, _, err := r.CreateRequest(ctx, changed); !errors.Is(err, domain.ErrIdempotencyMismatch) {
+		t.Fatalf("expected ErrIdempotencyMismatch, got %v", err)
+	}
+
+	// Requests without a key never collide.
+	plain := domain.NewRequest{URLs: []string{"https://example.com/a"}}
+	if mustCreate(t, r, plain) == mustCreate(t, r, plain) {
+		t.Fatal("expected separate requests without an idempotency key")
+	}
+
+	// Deleting the request frees its key.
+	if err := r.UpdateRequestStatus(ctx, ids[0], domain.StatusDone); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	if _, err := r.DeleteRequest(ctx, ids[0]); err != nil {
+		t.Fatalf("delete request: %v", err)
+	}
+	if id := mustCreate(t, r, changed); id == ids[0] {
+		t.Fatalf("expected a new request after the old one was deleted, got %d", id)
+	}
+}
+
+func testRetryFiles(t *testing.T, r repo) {
+	ctx := context.Background()
+	checksums := domain.Digests{SHA256: "expected"}
+	id, refs := mustCreateFiles(t, r, domain.NewRequest{
+		URLs:      []string{"https://example.com/ok", "https://example.com/bad", "https://example.com/late"},
+		Checksums: []domain.Digests{{}, checksums},
+		Timeout:   90 * time.Second,
+	})
+	if req, err := r.GetRequest(ctx, id); err != nil || req.Timeout != 90*time.Second {
+		t.Fatalf("expected the timeout to be stored, got %+v, %v", req, err)
+	}
+	if _, err := r.RetryFiles(ctx, id, []int{refs[1].ID}); !errors.Is(err, domain.ErrInProgress) {
+		t.Fatalf("expected ErrInProgress while the request runs, got %v", err)
+	}
+
+	started := time.Now().UTC().Truncate(time.Millisecond)
+	for _, ref := range refs[:2] {
+		if err := r.UpdateFileStatus(ctx, id, ref.ID, domain.FileDownloading, domain.FileMeta{StartedAt: &started}, nil); err != nil {
+			t.Fatalf("start file: %v", err)
+		}
+	}
+	if _, _, err := r.SaveFileContent(ctx, id, refs[0].ID, domain.StoredContent{Hash: "h", StorageKey: "k"}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save content: %v", err)
+	}
+	meta := domain.FileMeta{HTTPStatus: 500, StartedAt: &started, FinishedAt: &started}
+	if err := r.UpdateFileStatus(ctx, id, refs[1].ID, domain.FileFailed, meta, errors.New("HTTP_500")); err != nil {
+		t.Fatalf("fail file: %v", err)
+	}
+	if err := r.UpdateFileStatus(ctx, id, refs[2].ID, domain.FileSkipped, domain.FileMeta{}, errors.New("TIMEOUT")); err != nil {
+		t.Fatalf("skip file: %v", err)
+	}
+	if err := r.UpdateRequestStatus(ctx, id, domain.StatusPartial); err != nil {
+		t.Fatalf("finish request: %v", err)
+	}
+
+	// A downloaded file cannot be retried; nothing changes then.
+	if _, err := r.RetryFiles(ctx, id, []int{refs[1].ID, refs[0].ID}); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a downloaded file, got %v", err)
+	}
+	if files := mustFiles(t, r, id); files[1].State != domain.FileFailed {
+		t.Fatalf("expected the failed retry to change nothing, got %+v", files[1])
+	}
+
+	got, err := r.RetryFiles(ctx, id, []int{refs[1].ID, refs[2].ID})
+	if err != nil {
+		t.Fatalf("retry files: %v", err)
+	}
+	if !reflect.DeepEqual(got, refs[1:]) {
+		t.Fatalf("expected refs %+v, got %+v", refs[1:], got)
+	}
+	if _, err := r.RetryFiles(ctx, id, []int{refs[1].ID}); !errors.Is(err, domain.ErrInProgress) {
+		t.Fatalf("expected ErrInProgress for a second retry, got %v", err)
+	}
+
+	req, files, err := r.GetRequestStatus(ctx, id)
+	if err != nil {
+		t.Fatalf("get request status: %v", err)
+	}
+	if req.Status != domain.StatusProcess {
+		t.Fatalf("expected PROCESS, got %s", req.Status)
+	}
+	want := []struct {
+		state    domain.FileState
+		attempts int
+	}{{domain.FileSucceeded, 1}, {domain.FilePending, 1}, {domain.FilePending, 0}}
+	for i, f := range files {
+		if f.ID != refs[i].ID || f.State != want[i].state || f.Attempts != want[i].attempts {
+			t.Fatalf("unexpected file %d after the retry: %+v", i, f)
+		}
+	}
+	if files[1].Error != "" || files[1].HTTPStatus != 0 || files[1].StartedAt != nil || files[1].Checksums != checksums {
+		t.Fatalf("expected the last attempt to be cleared, got %+v", files[1])
+	}
+
+	if _, err := r.RetryFiles(ctx, id+1000, nil); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown request, got %v", err)
+	}
+}
+
+func testAppendFiles(t *testing.T, r repo) {
+	ctx := context.Background()
+	id, refs := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}})
+
+	checksums := domain.Digests{MD5: "expected"}
+	added, err := r.AppendFiles(ctx, id, domain.NewRequest{
+		URLs:      []string{"https://example.com/b", "https://example.com/a"},
+		Checksums: []domain.Digests{checksums},
+	}, false)
+	if err != nil {
+		t.Fatalf("append files: %v", err)
+	}
+	if len(added) != 2 || added[0].URL != "https://example.com/b" || added[0].Checksums != checksums ||
+		added[0].ID <= refs[0].ID || added[1].ID == added[0].ID {
+		t.Fatalf("unexpected added files %+v", added)
+	}
+	files := mustFiles(t, r, id)
+	if len(files) != 3 || files[1].ID != added[0].ID || files[2].State != domain.FilePending || files[1].Checksums != checksums {
+		t.Fatalf("unexpected files after appending %+v", files)
+	}
+
+	if err := r.UpdateRequestStatus(ctx, id, domain.StatusDone); err != nil {
+		t.Fatalf("finish request: %v", err)
+	}
+	more := domain.NewRequest{URLs: []string{"https://example.com/c"}}
+	if _, err := r.AppendFiles(ctx, id, more, false); !errors.Is(err, domain.ErrFinished) {
+		t.Fatalf("expected ErrFinished, got %v", err)
+	}
+	if got := mustFiles(t, r, id); len(got) != 3 {
+		t.Fatalf("expected no file to be added to a finished request, got %+v", got)
+	}
+	if _, err := r.AppendFiles(ctx, id, more, true); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	req, err := r.GetRequest(ctx, id)
+	if err != nil {
+		t.Fatalf("get request: %v", err)
+	}
+	if req.Status != domain.StatusProcess || len(mustFiles(t, r, id)) != 4 {
+		t.Fatalf("expected a reopened request with four files, got %+v", req)
+	}
+
+	if _, err := r.AppendFiles(ctx, id+1000, more, true); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown request, got %v", err)
+	}
+}
+
+func testEvents(t *testing.T, r repo) {
+	ctx := context.Background()
+	id, refs := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://example.com/a", "https://example.com/b"}})
+	other := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/c"}})
+
+	types := func(events []domain.Event) []domain.EventType {
+		var out []domain.EventType
+		for _, e := range events {
+			out = append(out, e.Type)
+		}
+		return out
+	}
+	mustEvents := func(afterID int64) []domain.Event {
+		t.Helper()
+		events, err := r.ListEvents(ctx, id, afterID, 100)
+		if err != nil {
+			t.Fatalf("list events: %v", err)
+		}
+		return events
+	}
+
+	for _, ref := range refs {
+		if err := r.UpdateFileStatus(ctx, id, ref.ID, domain.FileDownloading, domain.FileMeta{}, nil); err != nil {
+			t.Fatalf("start file: %v", err)
+		}
+	}
+	progress := []domain.FileProgress{{FileID: refs[0].ID, BytesDownloaded: 10, BytesTotal: 100}}
+	if err := r.RecordProgress(ctx, id, progress); err != nil {
+		t.Fatalf("record progress: %v", err)
+	}
+	progress[0].BytesDownloaded = 50
+	if err := r.RecordProgress(ctx, id, progress); err != nil {
+		t.Fatalf("record progress: %v", err)
+	}
+	events := mustEvents(0)
+	want := []domain.EventType{domain.EventFileStarted, domain.EventFileStarted, domain.EventFileProgress}
+	if !reflect.DeepEqual(types(events), want) {
+		t.Fatalf("expected %v, got %+v", want, events)
+	}
+	if p := events[2]; p.FileID != refs[0].ID || p.BytesDownloaded != 50 || p.BytesTotal != 100 || p.CreatedAt.IsZero() {
+		t.Fatalf("expected only the latest progress to be kept, got %+v", p)
+	}
+	afterProgress := events[2].ID
+	if got, err := r.ListProgress(ctx, id); err != nil || !reflect.DeepEqual(got, progress) {
+		t.Fatalf("expected the latest progress %+v, got %+v (%v)", progress, got, err)
+	}
+
+	if _, _, err := r.SaveFileContent(ctx, id, refs[0].ID, domain.StoredContent{Hash: "h", StorageKey: "k", Size: 100}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save content: %v", err)
+	}
+	if err := r.UpdateFileStatus(ctx, id, refs[1].ID, domain.FileFailed, domain.FileMeta{}, errors.New("HTTP_500")); err != nil {
+		t.Fatalf("fail file: %v", err)
+	}
+	// Progress of a file that already finished is dropped.
+	if err := r.RecordProgress(ctx, id, []domain.FileProgress{{FileID: refs[1].ID, BytesDownloaded: 5}}); err != nil {
+		t.Fatalf("record progress: %v", err)
+	}
+	if got, err := r.ListProgress(ctx, id); err != nil || len(got) != 0 {
+		t.Fatalf("expected no progress once the files finished, got %+v (%v)", got, err)
+	}
+	if err := r.UpdateRequestStatus(ctx, id, domain.StatusPartial); err != nil {
+		t.Fatalf("finish request: %v", err)
+	}
+	if err := r.UpdateFileStatus(ctx, other, 0, domain.FileFailed, domain.FileMeta{}, nil); err != nil {
+		t.Fatalf("update unknown file: %v", err)
+	}
+
+	events = mustEvents(afterProgress)
+	want = []domain.EventType{domain.EventFileSucceeded, domain.EventFileFailed, domain.EventRequestCompleted}
+	if !reflect.DeepEqual(types(events), want) {
+		t.Fatalf("expected %v after the progress, got %+v", want, events)
+	}
+	if e := events[0]; e.FileID != refs[0].ID || e.State != domain.FileSucceeded || e.BytesDownloaded != 100 {
+		t.Fatalf("unexpected succeeded event %+v", e)
+	}
+	if e := events[1]; e.FileID != refs[1].ID || e.State != domain.FileFailed || e.Error != "HTTP_500" {
+		t.Fatalf("unexpected failed event %+v", e)
+	}
+	if e := events[2]; e.FileID != 0 || e.Status != domain.StatusPartial || e.RequestID != id {
+		t.Fatalf("unexpected completed event %+v", e)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].ID <= events[i-1].ID {
+			t.Fatalf("expected increasing ids, got %+v", events)
+		}
+	}
+	// The superseded progress is gone, so the whole stream has five events.
+	if all := mustEvents(0); len(all) != 5 {
+		t.Fatalf("expected five events, got %+v", all)
+	}
+	if page, err := r.ListEvents(ctx, id, 0, 2); err != nil || len(page) != 2 {
+		t.Fatalf("expected a page of two events, got %+v, %v", page, err)
+	}
+	if got, err := r.ListEvents(ctx, other, 0, 100); err != nil || len(got) != 0 {
+		t.Fatalf("expected no events of the other request, got %+v, %v", got, err)
+	}
+
+	if _, err := r.DeleteRequest(ctx, id); err != nil {
+		t.Fatalf("delete request: %v", err)
+	}
+	if got := mustEvents(0); len(got) != 0 {
+		t.Fatalf("expected the events to be deleted with the request, got %+v", got)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, r repo) {
+	ctx := context.Background()
+	const callback = "https://hooks.example.com/done"
+	id := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}, CallbackURL: callback})
+	other := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/b"}})
+
+	req, err := r.GetRequest(ctx, id)
+	if err != nil || req.CallbackURL != callback {
+		t.Fatalf("expected the callback URL to round-trip, got %+v (%v)", req, err)
+	}
+	if req, err := r.GetRequest(ctx, other); err != nil || req.CallbackURL != "" {
+		t.Fatalf("expected no callback URL, got %+v (%v)", req, err)
+	}
+
+	attempts := []domain.WebhookDelivery{
+		{RequestID: id, URL: callback, Attempt: 1, Status: domain.DeliveryFailed, HTTPStatus: 503,
+			Error: "callback answered 503 Service Unavailable", Duration: 150 * time.Millisecond},
+		{RequestID: id, URL: callback, Attempt: 2, Status: domain.DeliverySucceeded, HTTPStatus: 204,
+			Duration: 40 * time.Millisecond},
+	}
+	for _, d := range attempts {
+		if err := r.RecordDelivery(ctx, d); err != nil {
+			t.Fatalf("record delivery: %v", err)
+		}
+	}
+
+	got, err := r.ListDeliveries(ctx, id)
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	if len(got) != len(attempts) || got[0].ID == 0 || got[1].ID <= got[0].ID {
+		t.Fatalf("expected two deliveries in order, got %+v", got)
+	}
+	for i, d := range got {
+		want := attempts[i]
+		want.ID = d.ID
+		if d.CreatedAt.IsZero() {
+			t.Fatalf("delivery %d: expected created_at to be set", i)
+		}
+		d.CreatedAt = time.Time{}
+		if d != want {
+			t.Fatalf("delivery %d: expected %+v, got %+v", i, want, d)
+		}
+	}
+	if got, err := r.ListDeliveries(ctx, other); err != nil || len(got) != 0 {
+		t.Fatalf("expected no deliveries for the other request, got %+v (%v)", got, err)
+	}
+
+	if _, err := r.DeleteRequest(ctx, id); err != nil {
+		t.Fatalf("delete request: %v", err)
+	}
+	if got, err := r.ListDeliveries(ctx, id); err != nil || len(got) != 0 {
+		t.Fatalf("expected deleting the request to drop its deliveries, got %+v (%v)", got, err)
+	}
+}
+
+func testSharedContent(t *testing.T, r repo) {
+	ctx := context.Background()
+	first, firstFiles := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}})
+	second, secondFiles := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://mirror.example.com/a"}})
+
+	key, _, err := r.SaveFileContent(ctx, first, firstFiles[0].ID,
+		domain.StoredContent{Hash: "same", StorageKey: "content/first", Size: 3, Encoding: domain.EncodingZstd}, domain.FileMeta{})
+	if err != nil {
+		t.Fatalf("save first: %v", err)
+	}
+	shared, _, err := r.SaveFileContent(ctx, second, secondFiles[0].ID,
+		domain.StoredContent{Hash: "same", StorageKey: "content/second", Size: 3, Encoding: domain.EncodingIdentity}, domain.FileMeta{})
+	if err != nil {
+		t.Fatalf("save second: %v", err)
+	}
+	if shared != key {
+		t.Fatalf("expected identical content to share %q, got %q", key, shared)
+	}
+	// The file follows the encoding of the blob it shares, not of its own upload.
+	if f, err := r.GetFile(ctx, second, secondFiles[0].ID); err != nil || f.Encoding != domain.EncodingZstd || f.Size != 3 {
+		t.Fatalf("expected the shared zstd blob, got %+v (%v)", f, err)
+	}
+
+	orphaned, err := r.DeleteRequest(ctx, first)
+	if err != nil {
+		t.Fatalf("delete first: %v", err)
+	}
+	if len(orphaned) != 0 {
+		t.Fatalf("content is still referenced, got orphans %v", orphaned)
+	}
+	orphaned, err = r.DeleteRequest(ctx, second)
+	if err != nil {
+		t.Fatalf("delete second: %v", err)
+	}
+	if !reflect.DeepEqual(orphaned, []string{key}) {
+		t.Fatalf("expected %q to be orphaned, got %v", key, orphaned)
+	}
+	if _, err := r.GetRequest(ctx, second); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected deleted request to be ErrNotFound, got %v", err)
+	}
+}
+
+func testReplacedContent(t *testing.T, r repo) {
+	ctx := context.Background()
+	id, files := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}})
+
+	if _, _, err := r.SaveFileContent(ctx, id, files[0].ID,
+		domain.StoredContent{Hash: "old", StorageKey: "content/old", Size: 1}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save old: %v", err)
+	}
+	_, orphaned, err := r.SaveFileContent(ctx, id, files[0].ID,
+		domain.StoredContent{Hash: "new", StorageKey: "content/new", Size: 2}, domain.FileMeta{})
+	if err != nil {
+		t.Fatalf("save new: %v", err)
+	}
+	if !reflect.DeepEqual(orphaned, []string{"content/old"}) {
+		t.Fatalf("expected the old content to be orphaned, got %v", orphaned)
+	}
+}
+
+func testDataKeys(t *testing.T, r repo) {
+	ctx := context.Background()
+	first, firstFiles := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}})
+	second, secondFiles := mustCreateFiles(t, r, domain.NewRequest{URLs: []string{"https://example.com/b", "https://example.com/c"}})
+
+	old := domain.DataKey{MasterKeyID: "old", Wrapped: []byte{1, 2, 3}}
+	if _, _, err := r.SaveFileContent(ctx, first, firstFiles[0].ID,
+		domain.StoredContent{Hash: "secret", StorageKey: "content/secret", Size: 3, DataKey: old}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save encrypted: %v", err)
+	}
+	// A second upload of the same content shares the blob and therefore its data key.
+	if _, _, err := r.SaveFileContent(ctx, second, secondFiles[0].ID,
+		domain.StoredContent{Hash: "secret", StorageKey: "content/other", Size: 3,
+			DataKey: domain.DataKey{MasterKeyID: "current", Wrapped: []byte{9}}}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save shared: %v", err)
+	}
+	if _, _, err := r.SaveFileContent(ctx, second, secondFiles[1].ID,
+		domain.StoredContent{Hash: "plain", StorageKey: "content/plain", Size: 3}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save plain: %v", err)
+	}
+	if f, err := r.GetFile(ctx, second, secondFiles[0].ID); err != nil || !reflect.DeepEqual(f.DataKey, old) {
+		t.Fatalf("expected the shared data key, got %+v (%v)", f, err)
+	}
+	if f, err := r.GetFile(ctx, second, secondFiles[1].ID); err != nil || f.DataKey.Encrypted() {
+		t.Fatalf("expected an unencrypted file, got %+v (%v)", f, err)
+	}
+
+	stale, err := r.ListStaleDataKeys(ctx, "current", 10)
+	if err != nil {
+		t.Fatalf("list stale keys: %v", err)
+	}
+	if !reflect.DeepEqual(stale, []domain.BlobKey{{Hash: "secret", Key: old}}) {
+		t.Fatalf("expected only the encrypted blob to be stale, got %+v", stale)
+	}
+
+	rotated := domain.DataKey{MasterKeyID: "current", Wrapped: []byte{4, 5, 6}}
+	if err := r.ReplaceDataKey(ctx, "secret", old, rotated); err != nil {
+		t.Fatalf("replace data key: %v", err)
+	}
+	// A replacement based on a key that is no longer current is ignored.
+	if err := r.ReplaceDataKey(ctx, "secret", old, domain.DataKey{MasterKeyID: "current", Wrapped: []byte{7}}); err != nil {
+		t.Fatalf("replace stale data key: %v", err)
+	}
+	for _, ref := range []struct{ request, file int }{{first, firstFiles[0].ID}, {second, secondFiles[0].ID}} {
+		if f, err := r.GetFile(ctx, ref.request, ref.file); err != nil || !reflect.DeepEqual(f.DataKey, rotated) {
+			t.Fatalf("expected the rotated data key, got %+v (%v)", f, err)
+		}
+	}
+	if stale, err := r.ListStaleDataKeys(ctx, "current", 10); err != nil || len(stale) != 0 {
+		t.Fatalf("expected no stale keys after rotation, got %+v (%v)", stale, err)
+	}
+}
+
+func testRetention(t *testing.T, r repo) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour)
+	earlier := now.Add(-2 * time.Hour)
+	future := now.Add(time.Hour)
+
+	expired := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/a"}, ExpiresAt: &past})
+	expiredEarlier := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/b"}, ExpiresAt: &earlier})
+	running := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/c"}, ExpiresAt: &past})
+	live := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/d"}, ExpiresAt: &future})
+	forever := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/e"}})
+	for _, id := range []int{expired, expiredEarlier, live, forever} {
+		if err := r.UpdateRequestStatus(ctx, id, domain.StatusDone); err != nil {
+			t.Fatalf("update status: %v", err)
+		}
+	}
+
+	ids, err := r.ListExpiredRequests(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("list expired: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int{expiredEarlier, expired}) {
+		t.Fatalf("expected %v, got %v (running %d)", []int{expiredEarlier, expired}, ids, running)
+	}
+	if ids, _ := r.ListExpiredRequests(ctx, now, 1); !reflect.DeepEqual(ids, []int{expiredEarlier}) {
+		t.Fatalf("expected the limit to apply, got %v", ids)
+	}
+
+	if _, err := r.PurgeRequest(ctx, expired); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if _, err := r.GetRequest(ctx, expired); !errors.Is(err, domain.ErrGone) {
+		t.Fatalf("expected ErrGone after purge, got %v", err)
+	}
+	if _, _, err := r.GetRequestStatus(ctx, expired); !errors.Is(err, domain.ErrGone) {
+		t.Fatalf("expected ErrGone from GetRequestStatus, got %v", err)
+	}
+	if _, err := r.PurgeRequest(ctx, expired); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected a second purge to be ErrNotFound, got %v", err)
+	}
+}
+
+func testConcurrentCreate(t *testing.T, r repo) {
+	const n = 20
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids = make(map[int]bool)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, _, err := r.CreateRequest(context.Background(), domain.NewRequest{URLs: []string{"https://example.com/a"}})
+			if err != nil {
+				t.Errorf("create request: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			ids[id] = true
+		}()
+	}
+	wg.Wait()
+	if len(ids) != n {
+		t.Fatalf("expected %d distinct ids, got %d", n, len(ids))
+	}
+}
+
+// testConcurrentSave mirrors the DownloadFileActivity runs of a round, which save files
+// of one request at the same time, here all with identical content.
+func testConcurrentSave(t *testing.T, r repo) {
+	ctx := context.Background()
+	const n = 10
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	id, files := mustCreateFiles(t, r, domain.NewRequest{URLs: urls})
+
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content := domain.StoredContent{Hash: "same", StorageKey: fmt.Sprintf("content/%d", i), Size: 1}
+			if _, _, err := r.SaveFileContent(ctx, id, file.ID, content, domain.FileMeta{}); err != nil {
+				t.Errorf("save content: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	keys := make(map[string]bool)
+	for _, f := range mustFiles(t, r, id) {
+		file, err := r.GetFile(ctx, id, f.ID)
+		if err != nil {
+			t.Fatalf("get file: %v", err)
+		}
+		keys[file.StorageKey] = true
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected all files to share one blob, got %v", keys)
+	}
+
+	if err := r.UpdateRequestStatus(ctx, id, domain.StatusDone); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	orphaned, err := r.DeleteRequest(ctx, id)
+	if err != nil {
+		t.Fatalf("delete request: %v", err)
+	}
+	if len(orphaned) != 1 || !keys[orphaned[0]] {
+		t.Fatalf("expected the shared blob to be released once, got %v", orphaned)
+	}
+}
+
+func testListRequests(t *testing.T, r repo) {
+	ctx := context.Background()
+	past := time.Now().UTC().Add(-time.Hour)
+
+	a := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://Example.com/Report_2026.pdf"}})
+	b := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://cdn.example.org:8443/a.iso", "http://user@mirror.test/b"}})
+	c := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/other"}})
+	expired := mustCreate(t, r, domain.NewRequest{URLs: []string{"https://example.com/old"}, ExpiresAt: &past})
+	if err := r.UpdateRequestStatus(ctx, b, domain.StatusDone); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+
+	list := func(q domain.ListRequestsQuery) []int {
+		t.Helper()
+		if q.Limit == 0 {
+			q.Limit = 10
+		}
+		requests, err := r.ListRequests(ctx, q)
+		if err != nil {
+			t.Fatalf("list requests: %v", err)
+		}
+		ids := []int{}
+		for _, req := range requests {
+			ids = append(ids, req.ID)
+		}
+		return ids
+	}
+	expect := func(name string, got, want []int) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+
+	now := time.Now()
+	expect("all", list(domain.ListRequestsQuery{}), []int{a, b, c, expired})
+	expect("descending", list(domain.ListRequestsQuery{Descending: true}), []int{expired, c, b, a})
+	// A request still downloading does not expire before it has finished.
+	expect("active", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{ActiveAt: &now}}), []int{a, b, c, expired})
+	expect("status", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{Status: domain.StatusDone}}), []int{b})
+	expect("status process", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{Status: domain.StatusProcess}}), []int{a, c, expired})
+	if err := r.UpdateRequestStatus(ctx, expired, domain.StatusError); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	expect("active once finished", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{ActiveAt: &now}}), []int{a, b, c})
+	expect("url", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{URLContains: "REPORT_"}}), []int{a})
+	expect("url wildcard", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{URLContains: "t_2"}}), []int{a})
+	expect("literal percent", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{URLContains: "%"}}), []int{})
+	expect("host", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{Host: "EXAMPLE.com"}}), []int{a, c, expired})
+	expect("host with port", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{Host: "cdn.example.org"}}), []int{b})
+	expect("host with user", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{Host: "mirror.test"}}), []int{b})
+
+	future := time.Now().Add(time.Hour)
+	expect("created range", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{CreatedTo: &future}}), []int{a, b, c, expired})
+	expect("created from", list(domain.ListRequestsQuery{Filter: domain.RequestFilter{CreatedFrom: &future}}), []int{})
+
+	// Page through in both directions with cursors taken from the returned rows.
+	for _, desc := range []bool{false, true} {
+		var got []int
+		var after *domain.RequestCursor
+		for {
+			page, err := r.ListRequests(ctx, domain.ListRequestsQuery{Descending: desc, After: after, Limit: 3})
+			if err != nil {
+				t.Fatalf("list page: %v", err)
+			}
+			for _, req := range page {
+				got = append(got, req.ID)
+			}
+			if len(page) < 3 {
+				break
+			}
+			last := page[len(page)-1]
+			after = &domain.RequestCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+		want := []int{a, b, c, expired}
+		if desc {
+			want = []int{expired, c, b, a}
+		}
+		expect(fmt.Sprintf("pages (descending %v)", desc), got, want)
+	}
+}
@@ -0,0 +1,13 @@
+package repository_test
+
+import (
+	"testing"
+
+	"async-file-storage/internal/repository"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	runConformance(t, func(t *testing.T) repo {
+		return repository.NewMemoryRepository()
+	})
+}
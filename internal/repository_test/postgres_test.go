@@ -0,0 +1,44 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"async-file-storage/internal/repository"
+)
+
+// TestPostgresRepository runs the conformance suite against TEST_DATABASE_URL.
+// The schema of that database is dropped and recreated for every subtest.
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	runConformance(t, func(t *testing.T) repo {
+		db, err := repository.OpenPostgres(dsn)
+		if err != nil {
+			t.Fatalf("open database: %v", err)
+		}
+		defer func() { _ = db.Close() }()
+
+		migrator, err := repository.NewPostgresMigrator(db)
+		if err != nil {
+			t.Fatalf("load migrations: %v", err)
+		}
+		ctx := context.Background()
+		if err := migrator.To(ctx, 0); err != nil {
+			t.Fatalf("reset schema: %v", err)
+		}
+		if err := migrator.Up(ctx); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+
+		r, err := repository.NewPostgresRepository(dsn)
+		if err != nil {
+			t.Fatalf("open repository: %v", err)
+		}
+		return r
+	})
+}
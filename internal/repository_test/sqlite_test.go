@@ -0,0 +1,183 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/repository"
+)
+
+func migrateSQLite(t *testing.T, path string) {
+	t.Helper()
+	db, err := repository.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	migrator, err := repository.NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	runConformance(t, func(t *testing.T) repo {
+		path := filepath.Join(t.TempDir(), "downloader.db")
+		migrateSQLite(t, path)
+
+		r, err := repository.NewSQLiteRepository(path)
+		if err != nil {
+			t.Fatalf("open repository: %v", err)
+		}
+		return r
+	})
+}
+
+func TestSQLiteRepository_RequiresMigratedSchema(t *testing.T) {
+	if _, err := repository.NewSQLiteRepository(filepath.Join(t.TempDir(), "empty.db")); err == nil {
+		t.Fatal("expected an error for an unmigrated database")
+	}
+}
+
+func TestSQLiteMigrations_DownAndUp(t *testing.T) {
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "downloader.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	migrator, err := repository.NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	ctx := context.Background()
+	for _, version := range []int{migrator.Latest(), 0, migrator.Latest()} {
+		if err := migrator.To(ctx, version); err != nil {
+			t.Fatalf("migrate to %d: %v", version, err)
+		}
+	}
+	if err := migrator.Check(ctx); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+}
+
+func TestSQLiteMigrations_BackfillsHost(t *testing.T) {
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "downloader.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	migrator, err := repository.NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	ctx := context.Background()
+	if err := migrator.To(ctx, 4); err != nil {
+		t.Fatalf("migrate to 4: %v", err)
+	}
+
+	hosts := map[string]string{
+		"https://Example.com/a/b?c=d#e":  "example.com",
+		"http://user:pw@cdn.test:8080/x": "cdn.test",
+		"http://[::1]:9000/blob":         "::1",
+		"https://plain.test":             "plain.test",
+		"ftp://files.test?list":          "files.test",
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO requests (id, status, created_at) VALUES (1, 'DONE', $1)", time.Now().UTC()); err != nil {
+		t.Fatalf("insert request: %v", err)
+	}
+	for url := range hosts {
+		if _, err := db.ExecContext(ctx, "INSERT INTO files (request_id, url) VALUES (1, $1)", url); err != nil {
+			t.Fatalf("insert file: %v", err)
+		}
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	for url, want := range hosts {
+		var got string
+		if err := db.QueryRowContext(ctx, "SELECT host FROM files WHERE url = $1", url).Scan(&got); err != nil {
+			t.Fatalf("read host: %v", err)
+		}
+		if got != want {
+			t.Errorf("host of %s: expected %q, got %q", url, want, got)
+		}
+	}
+}
+
+func TestSQLiteMigrations_BackfillsFileStates(t *testing.T) {
+	db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "downloader.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	migrator, err := repository.NewSQLiteMigrator(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	ctx := context.Background()
+	if err := migrator.To(ctx, 5); err != nil {
+		t.Fatalf("migrate to 5: %v", err)
+	}
+
+	// Before file states every finished request was DONE.
+	now := time.Now().UTC()
+	for id, status := range map[int]string{1: "DONE", 2: "DONE", 3: "DONE", 4: "PROCESS"} {
+		if _, err := db.ExecContext(ctx, "INSERT INTO requests (id, status, created_at) VALUES ($1, $2, $3)", id, status, now); err != nil {
+			t.Fatalf("insert request: %v", err)
+		}
+	}
+	files := []struct {
+		requestID int
+		url       string
+		key       any
+		errMsg    any
+	}{
+		{1, "https://example.com/ok", "files/ok", nil},
+		{2, "https://example.com/ok", "files/ok", nil},
+		{2, "https://example.com/bad", nil, "DOWNLOAD_FAILED"},
+		{3, "https://example.com/bad", nil, "TIMEOUT"},
+		{3, "https://example.com/never", nil, nil},
+		{4, "https://example.com/queued", nil, nil},
+	}
+	for _, f := range files {
+		_, err := db.ExecContext(ctx, "INSERT INTO files (request_id, url, storage_key, error_msg) VALUES ($1, $2, $3, $4)",
+			f.requestID, f.url, f.key, f.errMsg)
+		if err != nil {
+			t.Fatalf("insert file: %v", err)
+		}
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	for id, want := range map[int]string{1: "DONE", 2: "PARTIAL", 3: "ERROR", 4: "PROCESS"} {
+		var got string
+		if err := db.QueryRowContext(ctx, "SELECT status FROM requests WHERE id = $1", id).Scan(&got); err != nil {
+			t.Fatalf("read status: %v", err)
+		}
+		if got != want {
+			t.Errorf("status of request %d: expected %s, got %s", id, want, got)
+		}
+	}
+	wantStates := []string{"SUCCEEDED", "SUCCEEDED", "FAILED", "FAILED", "SKIPPED", "PENDING"}
+	for i, f := range files {
+		var got string
+		err := db.QueryRowContext(ctx, "SELECT state FROM files WHERE request_id = $1 AND url = $2", f.requestID, f.url).Scan(&got)
+		if err != nil {
+			t.Fatalf("read state: %v", err)
+		}
+		if got != wantStates[i] {
+			t.Errorf("state of %s in request %d: expected %s, got %s", f.url, f.requestID, wantStates[i], got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package statusview renders the status of a request: the body of GET /downloads/{id},
+// which completion webhooks deliver as well.
+package statusview
+
+import (
+	"encoding/json"
+	"time"
+
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/usecase"
+)
+
+// Request is the status of a request as clients receive it.
+type Request struct {
+	ID              int        `json:"id"`
+	Status          string     `json:"status"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	CallbackURL     string     `json:"callback_url,omitempty"`
+	BytesDownloaded int64      `json:"bytes_downloaded"`
+	BytesTotal      int64      `json:"bytes_total,omitempty"`
+	ETASeconds      int64      `json:"eta_seconds,omitempty"`
+	Files           []File     `json:"files"`
+}
+
+// File is the outcome of one file of a request.
+type File struct {
+	URL             string     `json:"url"`
+	ID              int        `json:"file_id,omitempty"`
+	State           string     `json:"state"`
+	Attempts        int        `json:"attempts"`
+	Size            *int64     `json:"size,omitempty"`
+	BytesDownloaded *int64     `json:"bytes_downloaded,omitempty"`
+	BytesTotal      int64      `json:"bytes_total,omitempty"`
+	ETASeconds      int64      `json:"eta_seconds,omitempty"`
+	SHA256          string     `json:"sha256,omitempty"`
+	SHA512          string     `json:"sha512,omitempty"`
+	MD5             string     `json:"md5,omitempty"`
+	ContentType     string     `json:"content_type,omitempty"`
+	FileName        string     `json:"filename,omitempty"`
+	HTTPStatus      int        `json:"http_status,omitempty"`
+	FinalURL        string     `json:"final_url,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	Error           *Error     `json:"error,omitempty"`
+}
+
+// Error is the error code of a file that did not download.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Marshal encodes the status of a request as JSON.
+func Marshal(out usecase.GetRequestOutput) ([]byte, error) {
+	return json.Marshal(New(out))
+}
+
+// New converts the status of a request into its JSON shape.
+func New(out usecase.GetRequestOutput) Request {
+	resp := Request{
+		ID:              out.ID,
+		Status:          string(out.Status),
+		ExpiresAt:       out.ExpiresAt,
+		CallbackURL:     out.CallbackURL,
+		BytesDownloaded: out.Progress.BytesDownloaded,
+		BytesTotal:      out.Progress.BytesTotal,
+		ETASeconds:      int64(out.Progress.ETA / time.Second),
+	}
+	resp.Files = make([]File, 0, len(out.Files))
+	for _, f := range out.Files {
+		item := File{
+			URL:         f.URL,
+			ID:          f.FileID,
+			State:       string(f.State),
+			Attempts:    f.Attempts,
+			SHA256:      f.Digests.SHA256,
+			SHA512:      f.Digests.SHA512,
+			MD5:         f.Digests.MD5,
+			ContentType: f.ContentType,
+			FileName:    f.FileName,
+			HTTPStatus:  f.HTTPStatus,
+			FinalURL:    f.FinalURL,
+			StartedAt:   f.StartedAt,
+			FinishedAt:  f.FinishedAt,
+		}
+		if f.ErrorCode != "" {
+			item.Error = &Error{Code: f.ErrorCode}
+		} else {
+			// A zero size is only meaningful once the content is stored.
+			if f.State == domain.FileSucceeded || f.Size > 0 {
+				size := f.Size
+				item.Size = &size
+			}
+		}
+		if p := f.Progress; p != nil {
+			downloaded := p.BytesDownloaded
+			item.BytesDownloaded = &downloaded
+			item.BytesTotal = p.BytesTotal
+			item.ETASeconds = int64(p.ETA / time.Second)
+		}
+		resp.Files = append(resp.Files, item)
+	}
+	return resp
+}
@@ -2,132 +2,664 @@ package temporal
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"mime"
 	"net/http"
+	"path"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"async-file-storage/internal/codec"
 	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
+	"async-file-storage/internal/webhook"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 )
 
 type Activities struct {
 	// можно сделать переменную приватной, я не увидел где ты ее присваиваешь извне,
 	// а так она может быть изменена в любой момент и это может привести к проблемам,
 	// если кто-то случайно присвоит ей другое значение
-	Repo domain.Storage
+	Repo  domain.Storage
+	Blobs domain.BlobStore
+	// MaxFileSize limits the size of a single file in bytes; zero means no limit.
+	MaxFileSize int64
+	// Compression picks the encoding content is stored with; the zero value stores it as downloaded.
+	Compression codec.Policy
+	// Keys encrypts new content with a fresh data key per blob; nil stores it in plaintext.
+	Keys *envelope.Keyring
+	// Signals passes download progress on to the workflow; nil leaves its ProgressQuery empty.
+	Signals WorkflowSignaler
+	// Webhooks notifies the callback URLs of finished requests; nil notifies nobody.
+	Webhooks WebhookSender
+}
+
+var errTooLarge = errors.New("file exceeds the maximum size")
+
+// httpClient has no overall timeout: a large body may legitimately take longer
+// than any fixed value, so the activity deadline bounds the transfer instead.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
 }
 
-// download multiple files and save to the DB
-// TODO: этот метод надо отрефакторить, слишком много кода
-func (a *Activities) DownloadFilesActivity(ctx context.Context, requestID int, urls []string, timeout time.Duration) ([]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+// DownloadFileActivity downloads one file of a request into the blob store and records its
+// outcome. A failed download is recorded like a successful one; only failing to record it is
+// an error, which the workflow retries. A file the deadline cuts off, or that would start
+// after it, is left to FinishRoundActivity, and on cancellation to CancelRequestActivity.
+func (a *Activities) DownloadFileActivity(ctx context.Context, requestID int, file domain.FileRef, deadline time.Time) (string, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
 	defer cancel()
+	if ctx.Err() != nil {
+		return "", interrupted(ctx)
+	}
+
+	// Cancellation of the workflow only reaches the activity through heartbeats.
+	progress := newProgressTracker()
+	stopHeartbeat := a.keepAlive(ctx, requestID, progress)
+	defer stopHeartbeat()
+	return a.downloadFile(ctx, requestID, file, progress)
+}
+
+// downloadFile is the body of DownloadFileActivity, for activities that keep themselves
+// alive: it tracks the download with progress but does not heartbeat.
+func (a *Activities) downloadFile(ctx context.Context, requestID int, file domain.FileRef, progress *progressTracker) (string, error) {
+	fmt.Printf("[%d] downloading: %s\n", file.ID, file.URL)
+
+	startedAt := time.Now().UTC()
+	if err := a.Repo.UpdateFileStatus(ctx, requestID, file.ID, domain.FileDownloading, domain.FileMeta{StartedAt: &startedAt}, nil); err != nil {
+		if ctx.Err() != nil {
+			return "", interrupted(ctx)
+		}
+		return "", fmt.Errorf("update file status: %w", err)
+	}
+
+	content, meta, downloadErr := a.downloadToStore(ctx, file.URL, file.Checksums, progress.start(file.ID))
+	progress.done(file.ID)
+	if ctx.Err() != nil {
+		if downloadErr == nil {
+			a.deleteBlobs(content.StorageKey)
+		}
+		return "", interrupted(ctx)
+	}
+
+	if downloadErr != nil {
+		if err := a.Repo.UpdateFileStatus(ctx, requestID, file.ID, domain.FileFailed, meta, downloadErr); err != nil {
+			return "", fmt.Errorf("update file status: %w", err)
+		}
+		return "", nil
+	}
+	if err := a.saveContent(ctx, requestID, file.ID, content, meta); err != nil {
+		return "", fmt.Errorf("save file content: %w", err)
+	}
+	return fmt.Sprintf("File %s processed successfully", file.URL), nil
+}
+
+// DownloadFilesActivity downloads a whole round in one activity, as DownloadWorkflow did
+// before it ran an activity per file. Runs started back then still schedule it, so it
+// stays registered until they have finished.
+func (a *Activities) DownloadFilesActivity(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) ([]string, error) {
+	downloadCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// One heartbeat covers the whole round; the files download without their own.
+	progress := newProgressTracker()
+	stopHeartbeat := a.keepAlive(downloadCtx, requestID, progress)
+	defer stopHeartbeat()
 
 	var (
 		wg      sync.WaitGroup
-		sem     = make(chan struct{}, 3)
-		results = make([]string, len(urls))
 		mu      sync.Mutex
-		done    = make([]bool, len(urls))
+		sem     = make(chan struct{}, maxParallelDownloads)
+		results = make([]string, len(files))
+		gaveUp  []int
 	)
-
-	for i, url := range urls {
-		if ctx.Err() != nil {
+	// No file starts once the deadline passed or the activity was canceled.
+	for i, file := range files {
+		select {
+		case sem <- struct{}{}:
+		case <-downloadCtx.Done():
+		}
+		if downloadCtx.Err() != nil {
 			break
 		}
-
 		wg.Add(1)
-		sem <- struct{}{}
-
-		index := i
-		link := url
-
 		go func() {
 			defer wg.Done()
 			defer func() { <-sem }()
-
-			if ctx.Err() != nil {
-				return
+			result, err := a.downloadFile(downloadCtx, requestID, file, progress)
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = result
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fmt.Printf("download error: %v\n", err)
+				gaveUp = append(gaveUp, file.ID)
 			}
+		}()
+	}
+	wg.Wait()
 
-			// TODO: надо еще обрабатывать случай, если произойдет таймаут через select и ctx.Done(), чтобы не запускать загрузку, которая уже не нужна
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+	statusCtx, cancelStatus := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelStatus()
+	if err := a.FinishRoundActivity(statusCtx, requestID, files, gaveUp); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
 
-			fmt.Printf("[%d] downloading: %s\n", index, link)
+// interrupted is what DownloadFileActivity returns once ctx is done: the cancellation, or
+// nil when the deadline passed, as the file is then FinishRoundActivity's to record.
+func interrupted(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	return nil
+}
 
-			data, downloadErr := downloadHelper(ctx, link)
-			if downloadErr != nil {
-				downloadErr = mapDownloadError(downloadErr)
-			}
+// FinishRoundActivity records the files of a round that ended without an outcome, then
+// the request status. Files whose activity gave up fail with WORKFLOW_FAILED. The others
+// ran out of time: a started file failed with TIMEOUT, one never started was skipped.
+func (a *Activities) FinishRoundActivity(ctx context.Context, requestID int, round []domain.FileRef, gaveUp []int) error {
+	_, files, err := a.Repo.GetRequestStatus(ctx, requestID)
+	if err != nil {
+		return fmt.Errorf("load request files: %w", err)
+	}
+	inRound := make(map[int]bool, len(round))
+	for _, f := range round {
+		inRound[f.ID] = true
+	}
+	finished := time.Now().UTC()
+	for _, f := range files {
+		if f.State.Finished() || !inRound[f.ID] {
+			continue
+		}
+		state, meta, reason := domain.FileFailed, f.FileMeta, "TIMEOUT"
+		switch {
+		case slices.Contains(gaveUp, f.ID):
+			reason = "WORKFLOW_FAILED"
+		case meta.StartedAt == nil:
+			state = domain.FileSkipped
+		}
+		if meta.StartedAt != nil && meta.FinishedAt == nil {
+			meta.FinishedAt = &finished
+		}
+		if err := a.Repo.UpdateFileStatus(ctx, requestID, f.ID, state, meta, errors.New(reason)); err != nil {
+			return fmt.Errorf("update file status: %w", err)
+		}
+	}
+	return a.finishRequest(ctx, requestID, round)
+}
+
+// finishRequest derives the final request status from the states of its files. A request
+// with unfinished files outside this round stays PROCESS: they were added during the run,
+// and the workflow downloads them next.
+func (a *Activities) finishRequest(ctx context.Context, requestID int, round []domain.FileRef) error {
+	_, files, err := a.Repo.GetRequestStatus(ctx, requestID)
+	if err != nil {
+		return fmt.Errorf("load request files: %w", err)
+	}
+	inRound := make(map[int]bool, len(round))
+	for _, f := range round {
+		inRound[f.ID] = true
+	}
+	for _, f := range files {
+		if !f.State.Finished() && !inRound[f.ID] {
+			return nil
+		}
+	}
+	if err := a.Repo.UpdateRequestStatus(ctx, requestID, domain.AggregateStatus(files)); err != nil {
+		return fmt.Errorf("update request status: %w", err)
+	}
+	return nil
+}
+
+// FailRequestActivity marks a request whose download workflow gave up as ERROR.
+// Files that never reached a final state are marked FAILED; the metadata they
+// collected so far is kept.
+func (a *Activities) FailRequestActivity(ctx context.Context, requestID int) error {
+	_, files, err := a.Repo.GetRequestStatus(ctx, requestID)
+	if err != nil {
+		return fmt.Errorf("load request files: %w", err)
+	}
+	for _, f := range files {
+		if f.State.Finished() {
+			continue
+		}
+		if err := a.Repo.UpdateFileStatus(ctx, requestID, f.ID, domain.FileFailed, f.FileMeta, errors.New("WORKFLOW_FAILED")); err != nil {
+			return fmt.Errorf("update file status: %w", err)
+		}
+	}
+	if err := a.Repo.UpdateRequestStatus(ctx, requestID, domain.StatusError); err != nil {
+		return fmt.Errorf("update request status: %w", err)
+	}
+	return nil
+}
+
+// CancelRequestActivity marks a request the client canceled as CANCELED. Files
+// that never reached a final state are marked CANCELED; the metadata they
+// collected so far is kept.
+func (a *Activities) CancelRequestActivity(ctx context.Context, requestID int) error {
+	_, files, err := a.Repo.GetRequestStatus(ctx, requestID)
+	if err != nil {
+		return fmt.Errorf("load request files: %w", err)
+	}
+	finished := time.Now().UTC()
+	for _, f := range files {
+		if f.State.Finished() {
+			continue
+		}
+		meta := f.FileMeta
+		if meta.StartedAt != nil && meta.FinishedAt == nil {
+			meta.FinishedAt = &finished
+		}
+		if err := a.Repo.UpdateFileStatus(ctx, requestID, f.ID, domain.FileCanceled, meta, errors.New("CANCELED")); err != nil {
+			return fmt.Errorf("update file status: %w", err)
+		}
+	}
+	if err := a.Repo.UpdateRequestStatus(ctx, requestID, domain.StatusCanceled); err != nil {
+		return fmt.Errorf("update request status: %w", err)
+	}
+	return nil
+}
+
+// DeliverWebhookActivity posts the status of a finished request to its callback URL and
+// records the attempt. Responses that will not change on a retry fail it for good.
+func (a *Activities) DeliverWebhookActivity(ctx context.Context, requestID int) error {
+	if a.Webhooks == nil {
+		return nil
+	}
+	req, files, err := a.Repo.GetRequestStatus(ctx, requestID)
+	if errors.Is(err, domain.ErrNotFound) || errors.Is(err, domain.ErrGone) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load request: %w", err)
+	}
+	if req.CallbackURL == "" {
+		return nil
+	}
+
+	attempt := 1
+	if activity.IsActivity(ctx) {
+		attempt = int(activity.GetInfo(ctx).Attempt)
+	}
+	started := time.Now()
+	httpStatus, sendErr := a.Webhooks.Send(ctx, req, files)
+	delivery := domain.WebhookDelivery{
+		RequestID:  requestID,
+		URL:        req.CallbackURL,
+		Attempt:    attempt,
+		Status:     domain.DeliverySucceeded,
+		HTTPStatus: httpStatus,
+		Duration:   time.Since(started),
+	}
+	if sendErr != nil {
+		delivery.Status = domain.DeliveryFailed
+		delivery.Error = sendErr.Error()
+	}
+	if err := a.Repo.RecordDelivery(ctx, delivery); err != nil {
+		fmt.Printf("record delivery error: %v\n", err)
+	}
+
+	var statusErr *webhook.StatusError
+	if errors.As(sendErr, &statusErr) && !statusErr.Retryable() {
+		return temporal.NewNonRetryableApplicationError(sendErr.Error(), "CALLBACK_REJECTED", sendErr)
+	}
+	return sendErr
+}
+
+// heartbeatInterval is how often a running download reports that it is alive.
+// It must stay well below the heartbeat timeout set by the workflow.
+const heartbeatInterval = 5 * time.Second
 
-			if dbErr := a.Repo.UpdateFileStatus(ctx, requestID, link, data, downloadErr); dbErr != nil {
-				fmt.Printf("db update error: %v\n", dbErr)
+// keepAlive heartbeats the progress of the downloads until the returned function is
+// called or ctx is done, and records progress that changed, which the request status and
+// its event stream read. The workflow is only told every progressSignalInterval, so its
+// history grows slowly. Stopping waits for a report in flight, so none arrives after the
+// activity completed. Outside an activity, as in tests, it does nothing.
+func (a *Activities) keepAlive(ctx context.Context, requestID int, progress *progressTracker) (stop func()) {
+	if !activity.IsActivity(ctx) {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		var reported, signaled []domain.FileProgress
+		var lastSignal time.Time
+		for {
+			select {
+			case <-ticker.C:
+				snapshot := progress.snapshot()
+				activity.RecordHeartbeat(ctx, snapshot)
+				if !slices.Equal(snapshot, reported) {
+					if err := a.Repo.RecordProgress(ctx, requestID, changedProgress(snapshot, reported)); err != nil {
+						fmt.Printf("progress record error: %v\n", err)
+					}
+					reported = snapshot
+				}
+				if !slices.Equal(snapshot, signaled) && time.Since(lastSignal) >= progressSignalInterval {
+					a.reportProgress(ctx, changedProgress(snapshot, signaled))
+					signaled, lastSignal = snapshot, time.Now()
+				}
+			case <-ctx.Done():
+				return
+			case <-done:
 				return
 			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
 
-			if downloadErr == nil {
-				results[index] = fmt.Sprintf("File %s processed successfully", link)
-			}
+// reportProgress signals the progress of the downloads to the workflow running the activity.
+func (a *Activities) reportProgress(ctx context.Context, changed []domain.FileProgress) {
+	if a.Signals == nil || len(changed) == 0 {
+		return
+	}
+	run := activity.GetInfo(ctx).WorkflowExecution
+	if err := a.Signals.SignalWorkflow(ctx, run.ID, run.RunID, ProgressSignal, changed); err != nil {
+		fmt.Printf("progress signal error: %v\n", err)
+	}
+}
 
-			mu.Lock()
-			// TODO: лучше писать defer mu.Unlock() сразу после Lock
-			// если я здесь пропишу panic("something"), у тебя произойдет deadlock, потому что Unlock не вызовется,
-			// а так, даже если будет паника, Unlock все равно вызовется и другие горутины не будут висеть в ожидании разблокировки
-			done[index] = true
-			mu.Unlock() // это в defer
-		}()
+// changedProgress returns the entries of snapshot that differ from those in previous.
+func changedProgress(snapshot, previous []domain.FileProgress) []domain.FileProgress {
+	var changed []domain.FileProgress
+	for _, p := range snapshot {
+		if !slices.Contains(previous, p) {
+			changed = append(changed, p)
+		}
 	}
+	return changed
+}
 
-	wg.Wait()
+// downloadToStore streams the response body straight into the blob store under a fresh key,
+// hashing it on the way, so the file is never held in memory. Content that does not match
+// the expected checksums is deleted again. The metadata is filled in as far as the download
+// got, also when it fails. The returned error is already mapped to an error code.
+func (a *Activities) downloadToStore(ctx context.Context, url string, checksums domain.Digests, progress *fileProgress) (content domain.StoredContent, meta domain.FileMeta, err error) {
+	started := time.Now().UTC()
+	meta.StartedAt = &started
+	defer func() {
+		finished := time.Now().UTC()
+		meta.FinishedAt = &finished
+	}()
 
-	if ctx.Err() != nil {
-		statusCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		for i, url := range urls {
-			mu.Lock()
-			alreadyDone := done[i]
-			mu.Unlock()
-			if alreadyDone {
-				continue
-			}
-			_ = a.Repo.UpdateFileStatus(statusCtx, requestID, url, nil, errors.New("TIMEOUT"))
+	resp, err := downloadHelper(ctx, url, a.MaxFileSize)
+	if resp != nil {
+		meta = responseMeta(resp, meta)
+	}
+	if err != nil {
+		return domain.StoredContent{}, meta, mapDownloadError(err)
+	}
+	defer resp.Body.Close()
+	progress.expect(resp.ContentLength)
+
+	src := &sourceReader{r: resp.Body, remaining: a.MaxFileSize, limited: a.MaxFileSize > 0}
+	digests := newDigester(checksums)
+	size := &byteCounter{}
+	key := "content/" + uuid.NewString()
+	stored := domain.StoredContent{StorageKey: key, Encoding: a.Compression.Choose(meta.ContentType, resp.ContentLength)}
+	var dataKey []byte
+	if a.Keys != nil {
+		if dataKey, stored.DataKey, err = a.Keys.NewDataKey(); err != nil {
+			fmt.Printf("data key error: %v\n", err)
+			return domain.StoredContent{}, meta, errors.New("STORAGE_FAILED")
 		}
 	}
+	err = a.putEncoded(ctx, key, stored.Encoding, dataKey, io.TeeReader(src, io.MultiWriter(digests, size, progress)))
+	if err != nil {
+		a.deleteBlobs(key)
 
-	statusCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := a.Repo.UpdateRequestStatus(statusCtx, requestID, domain.StatusDone); err != nil {
-		return nil, fmt.Errorf("update request status: %w", err)
+		if src.err != nil {
+			return domain.StoredContent{}, meta, mapDownloadError(src.err)
+		}
+		fmt.Printf("blob put error: %v\n", err)
+		return domain.StoredContent{}, meta, errors.New("STORAGE_FAILED")
+	}
+	meta.Digests = digests.Sum()
+	if !meta.Digests.Matches(checksums) {
+		a.deleteBlobs(key)
+		return domain.StoredContent{}, meta, errors.New("CHECKSUM_MISMATCH")
 	}
+	stored.Hash = meta.Digests.SHA256
+	stored.Size = size.n
+	return stored, meta, nil
+}
 
-	return results, nil
+// digester computes SHA-256, which content is stored under, and whichever other
+// digests the client expects.
+type digester struct {
+	sha256, sha512, md5 hash.Hash
+	w                   io.Writer
+}
+
+func newDigester(expected domain.Digests) *digester {
+	d := &digester{sha256: sha256.New()}
+	writers := []io.Writer{d.sha256}
+	if expected.SHA512 != "" {
+		d.sha512 = sha512.New()
+		writers = append(writers, d.sha512)
+	}
+	if expected.MD5 != "" {
+		d.md5 = md5.New()
+		writers = append(writers, d.md5)
+	}
+	d.w = io.MultiWriter(writers...)
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	return d.w.Write(p)
+}
+
+// Sum returns the hex digests of everything written so far.
+func (d *digester) Sum() domain.Digests {
+	sums := domain.Digests{SHA256: hex.EncodeToString(d.sha256.Sum(nil))}
+	if d.sha512 != nil {
+		sums.SHA512 = hex.EncodeToString(d.sha512.Sum(nil))
+	}
+	if d.md5 != nil {
+		sums.MD5 = hex.EncodeToString(d.md5.Sum(nil))
+	}
+	return sums
+}
+
+// putEncoded uploads r under key, compressing it with encoding and then encrypting it
+// with dataKey, if any. The encoders run in their own goroutine, which has finished
+// reading r by the time putEncoded returns.
+func (a *Activities) putEncoded(ctx context.Context, key string, encoding domain.ContentEncoding, dataKey []byte, r io.Reader) error {
+	if encoding == domain.EncodingIdentity && dataKey == nil {
+		_, err := a.Blobs.Put(ctx, key, r)
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pw.CloseWithError(encodeTo(pw, encoding, dataKey, r))
+	}()
+
+	_, err := a.Blobs.Put(ctx, key, pr)
+	// Unblocks the encoder if Put gave up before reading everything.
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	return err
+}
+
+// encodeTo writes r to w compressed and, with a data key, encrypted.
+func encodeTo(w io.Writer, encoding domain.ContentEncoding, dataKey []byte, r io.Reader) error {
+	sink := io.WriteCloser(nopWriteCloser{w})
+	if dataKey != nil {
+		enc, err := envelope.NewWriter(dataKey, w)
+		if err != nil {
+			return err
+		}
+		sink = enc
+	}
+	compressor, err := codec.NewWriter(encoding, sink)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(compressor, r); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	return sink.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// responseMeta records what the origin reported in its final response.
+func responseMeta(resp *http.Response, meta domain.FileMeta) domain.FileMeta {
+	meta.HTTPStatus = resp.StatusCode
+	meta.ContentType = resp.Header.Get("Content-Type")
+	if resp.Request != nil && resp.Request.URL != nil {
+		meta.FinalURL = resp.Request.URL.String()
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		meta.FileName = baseName(params["filename"])
+	}
+	return meta
+}
+
+// baseName strips any directory part from a file name suggested by the origin.
+func baseName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
 }
 
-// actual HTTP request to get the file bytes
-func downloadHelper(ctx context.Context, url string) ([]byte, error) {
+// saveContent attaches the uploaded blob to the file. When identical content is already
+// stored the new upload is dropped and the file shares the existing blob.
+func (a *Activities) saveContent(ctx context.Context, requestID, fileID int, content domain.StoredContent, meta domain.FileMeta) error {
+	key, orphaned, err := a.Repo.SaveFileContent(ctx, requestID, fileID, content, meta)
+	if err != nil {
+		a.deleteBlobs(content.StorageKey)
+		return err
+	}
+	if key != content.StorageKey {
+		orphaned = append(orphaned, content.StorageKey)
+	}
+	a.deleteBlobs(orphaned...)
+	return nil
+}
+
+// deleteBlobs removes blobs on a best-effort basis; it runs even after the activity context is done.
+func (a *Activities) deleteBlobs(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, key := range keys {
+		if err := a.Blobs.Delete(ctx, key); err != nil {
+			fmt.Printf("blob delete error: %v\n", err)
+		}
+	}
+}
+
+// downloadHelper sends the HTTP request and returns the response for streaming its body.
+// A Content-Length above maxSize is rejected before any byte is read. When the origin
+// answered but the answer is rejected, the response is returned along with the error,
+// with its body already closed.
+func downloadHelper(ctx context.Context, url string, maxSize int64) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 
-	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		_ = resp.Body.Close()
+		return resp, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		_ = resp.Body.Close()
+		return resp, errTooLarge
 	}
-	return io.ReadAll(resp.Body)
+	return resp, nil
+}
 
+// sourceReader wraps the response body. It enforces the size limit and remembers
+// read errors, so a failed Put can be told apart from a failed download.
+type sourceReader struct {
+	r         io.Reader
+	remaining int64
+	limited   bool
+	err       error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	if s.limited && int64(len(p)) > s.remaining+1 {
+		p = p[:s.remaining+1]
+	}
+	n, err := s.r.Read(p)
+	if s.limited {
+		s.remaining -= int64(n)
+		if s.remaining < 0 {
+			s.err = errTooLarge
+			return n, errTooLarge
+		}
+	}
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
 }
 
 func mapDownloadError(err error) error {
+	if errors.Is(err, errTooLarge) {
+		return errors.New("TOO_LARGE")
+	}
 	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
 		return errors.New("TIMEOUT")
 	}
@@ -0,0 +1,90 @@
+package temporal
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"async-file-storage/internal/domain"
+)
+
+const (
+	// ProgressSignal carries the []domain.FileProgress of the files a download activity
+	// is working on that changed since its previous report to the workflow.
+	ProgressSignal = "progress"
+	// ProgressQuery returns the []domain.FileProgress last reported to the workflow for
+	// the files still downloading.
+	ProgressQuery = "progress"
+)
+
+// progressSignalInterval is how often at most a download activity reports its progress
+// to the workflow. Each report is an event in the workflow history, so it is far rarer
+// than the heartbeats and the progress recorded for the request status.
+const progressSignalInterval = 30 * time.Second
+
+// WorkflowSignaler sends signals to workflows; client.Client implements it.
+type WorkflowSignaler interface {
+	SignalWorkflow(ctx context.Context, workflowID, runID, signalName string, arg interface{}) error
+}
+
+// progressTracker counts the bytes received for the files one activity run is downloading.
+type progressTracker struct {
+	mu    sync.Mutex
+	files map[int]*domain.FileProgress
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{files: make(map[int]*domain.FileProgress)}
+}
+
+// start begins tracking a file and returns the writer its downloaded bytes go through.
+func (t *progressTracker) start(fileID int) *fileProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[fileID] = &domain.FileProgress{FileID: fileID, StartedAt: time.Now().UTC()}
+	return &fileProgress{tracker: t, fileID: fileID}
+}
+
+// done stops tracking a file once its outcome is recorded.
+func (t *progressTracker) done(fileID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.files, fileID)
+}
+
+// snapshot returns the progress of every tracked file, ordered by file id.
+func (t *progressTracker) snapshot() []domain.FileProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]domain.FileProgress, 0, len(t.files))
+	for _, p := range t.files {
+		out = append(out, *p)
+	}
+	slices.SortFunc(out, func(a, b domain.FileProgress) int { return a.FileID - b.FileID })
+	return out
+}
+
+// fileProgress counts the bytes written to it towards one file.
+type fileProgress struct {
+	tracker *progressTracker
+	fileID  int
+}
+
+func (p *fileProgress) Write(b []byte) (int, error) {
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	if f, ok := p.tracker.files[p.fileID]; ok {
+		f.BytesDownloaded += int64(len(b))
+	}
+	return len(b), nil
+}
+
+// expect records the size the origin announced.
+func (p *fileProgress) expect(total int64) {
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	if f, ok := p.tracker.files[p.fileID]; ok && total > 0 {
+		f.BytesTotal = total
+	}
+}
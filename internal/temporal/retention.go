@@ -0,0 +1,73 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"async-file-storage/internal/domain"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// maxRetentionBatches bounds the work, and the history size, of one retention run.
+// Whatever is left is picked up by the next scheduled run.
+const maxRetentionBatches = 100
+
+// RetentionWorkflow purges expired requests batch by batch. It is started by a Temporal schedule.
+func RetentionWorkflow(ctx workflow.Context, batchSize int) (int, error) {
+	options := workflow.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, options)
+	logger := workflow.GetLogger(ctx)
+
+	var a *Activities
+	total := 0
+	for i := 0; i < maxRetentionBatches; i++ {
+		var purged int
+		if err := workflow.ExecuteActivity(ctx, a.PurgeExpiredActivity, batchSize).Get(ctx, &purged); err != nil {
+			logger.Error("Retention run failed", "Purged", total, "Error", err)
+			return total, err
+		}
+		total += purged
+		if purged < batchSize {
+			break
+		}
+	}
+
+	logger.Info("Retention run completed", "Purged", total)
+	return total, nil
+}
+
+// PurgeExpiredActivity deletes one batch of expired requests together with the
+// blobs that no other file references. It returns the number of purged requests.
+func (a *Activities) PurgeExpiredActivity(ctx context.Context, batchSize int) (int, error) {
+	ids, err := a.Repo.ListExpiredRequests(ctx, time.Now(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("list expired requests: %w", err)
+	}
+
+	purged := 0
+	for _, id := range ids {
+		orphaned, err := a.Repo.PurgeRequest(ctx, id)
+		if errors.Is(err, domain.ErrNotFound) {
+			// Deleted by a user in the meantime.
+			continue
+		}
+		if err != nil {
+			return purged, fmt.Errorf("purge request %d: %w", id, err)
+		}
+		a.deleteBlobs(orphaned...)
+		purged++
+	}
+	return purged, nil
+}
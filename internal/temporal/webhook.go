@@ -0,0 +1,41 @@
+package temporal
+
+import (
+	"context"
+	"time"
+
+	"async-file-storage/internal/domain"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// WebhookSender posts the status of a request to its callback URL and returns the HTTP
+// status of the response; *webhook.Sender implements it.
+type WebhookSender interface {
+	Send(ctx context.Context, req *domain.DownloadRequest, files []domain.FileEntry) (int, error)
+}
+
+// webhookRetryPolicy spreads the attempts to deliver a webhook over about forty minutes.
+var webhookRetryPolicy = &temporal.RetryPolicy{
+	InitialInterval:    10 * time.Second,
+	BackoffCoefficient: 2.0,
+	MaximumInterval:    10 * time.Minute,
+	MaximumAttempts:    10,
+}
+
+// deliverWebhook tells the callback URL of a request, if it has one, that the request
+// finished. Like finalize it runs in a disconnected context, so a canceled request is
+// reported too. A delivery that gives up is logged and leaves the workflow result alone.
+func deliverWebhook(ctx workflow.Context, requestID int) {
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy:         webhookRetryPolicy,
+	})
+
+	var a *Activities
+	if err := workflow.ExecuteActivity(ctx, a.DeliverWebhookActivity, requestID).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to deliver webhook", "RequestID", requestID, "Error", err)
+	}
+}
@@ -1,18 +1,34 @@
 package temporal
 
 import (
+	"slices"
 	"time"
 
+	"async-file-storage/internal/domain"
+
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
+// AddFilesSignal delivers a []domain.FileRef of files added to a running request.
+const AddFilesSignal = "add-files"
+
+// maxParallelDownloads is how many files of a request download at the same time.
+const maxParallelDownloads = 3
+
+// perFileActivitiesChange is the workflow.GetVersion change id of running one activity per file.
+const perFileActivitiesChange = "per-file-activities"
+
 // DownloadWorkflow orchestrates the file downloading process.
 // Now it takes requestID to track progress in the database.
-func DownloadWorkflow(ctx workflow.Context, requestID int, urls []string, timeout time.Duration) ([]string, error) {
-	// Define Activity options: timeout and retry policy
+func DownloadWorkflow(ctx workflow.Context, requestID int, files []domain.FileRef, timeout time.Duration) ([]string, error) {
+	// Define Activity options: timeout and retry policy, which apply to each file
 	options := workflow.ActivityOptions{
 		StartToCloseTimeout: timeout + time.Minute,
+		// Heartbeats deliver cancellation to the activity; waiting for it lets
+		// the aborted transfers settle before the files are marked CANCELED.
+		HeartbeatTimeout:    30 * time.Second,
+		WaitForCancellation: true,
 		RetryPolicy: &temporal.RetryPolicy{
 			InitialInterval:    time.Second,
 			BackoffCoefficient: 2.0,
@@ -23,20 +39,153 @@ func DownloadWorkflow(ctx workflow.Context, requestID int, urls []string, timeou
 	ctx = workflow.WithActivityOptions(ctx, options)
 
 	logger := workflow.GetLogger(ctx)
-	logger.Info("Workflow started", "RequestID", requestID, "URLCount", len(urls))
+	logger.Info("Workflow started", "RequestID", requestID, "FileCount", len(files))
 
 	// Define our activities container
 	var a *Activities
 
-	// Execute the downloading activity
-	var results []string
-	err := workflow.ExecuteActivity(ctx, a.DownloadFilesActivity, requestID, urls, timeout).Get(ctx, &results)
+	// Runs started before each file got an activity of its own keep downloading a round
+	// in one DownloadFilesActivity, so that their histories still replay.
+	perFile := workflow.GetVersion(ctx, perFileActivitiesChange, workflow.DefaultVersion, 1) == 1
 
-	if err != nil {
-		logger.Error("Workflow failed", "Error", err)
+	// The activities report the progress of their downloads as signals; the query
+	// answers with the last report of each file still downloading.
+	progress := make(map[int]domain.FileProgress)
+	if err := workflow.SetQueryHandler(ctx, ProgressQuery, func() ([]domain.FileProgress, error) {
+		out := make([]domain.FileProgress, 0, len(progress))
+		for _, p := range progress {
+			out = append(out, p)
+		}
+		slices.SortFunc(out, func(a, b domain.FileProgress) int { return a.FileID - b.FileID })
+		return out, nil
+	}); err != nil {
 		return nil, err
 	}
+	reports := workflow.GetSignalChannel(ctx, ProgressSignal)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var report []domain.FileProgress
+			reports.Receive(ctx, &report)
+			for _, p := range report {
+				progress[p.FileID] = p
+			}
+		}
+	})
+
+	// Files added while the workflow runs arrive as signals. They are picked up after
+	// the current round, and the workflow only completes once none are left over.
+	added := workflow.GetSignalChannel(ctx, AddFilesSignal)
+
+	var results []string
+	for {
+		files = append(files, receiveAddedFiles(added)...)
+		if len(files) == 0 {
+			// The request has finished. Files added while its webhook is delivered
+			// reopened it; they are downloaded before the workflow completes.
+			deliverWebhook(ctx, requestID)
+			if files = receiveAddedFiles(added); len(files) == 0 {
+				break
+			}
+		}
+
+		var round []string
+		var err error
+		if perFile {
+			// The timeout applies to the round as a whole
+			deadline := workflow.Now(ctx).Add(timeout)
+			var gaveUp []int
+			round, gaveUp = downloadRound(ctx, requestID, files, deadline, progress)
+			if err = ctx.Err(); err == nil {
+				err = workflow.ExecuteActivity(ctx, a.FinishRoundActivity, requestID, files, gaveUp).Get(ctx, nil)
+			}
+		} else {
+			err = workflow.ExecuteActivity(ctx, a.DownloadFilesActivity, requestID, files, timeout).Get(ctx, &round)
+			for _, file := range files {
+				delete(progress, file.ID)
+			}
+		}
+
+		if temporal.IsCanceledError(err) {
+			logger.Info("Workflow canceled", "RequestID", requestID)
+			finalize(ctx, requestID, a.CancelRequestActivity, "Failed to mark request as canceled")
+			deliverWebhook(ctx, requestID)
+			return nil, err
+		}
+		if err != nil {
+			logger.Error("Workflow failed", "Error", err)
+			finalize(ctx, requestID, a.FailRequestActivity, "Failed to mark request as failed")
+			deliverWebhook(ctx, requestID)
+			return nil, err
+		}
+		results = append(results, round...)
+		files = nil
+	}
 
 	logger.Info("Workflow completed successfully", "RequestID", requestID)
 	return results, nil
 }
+
+// downloadRound runs one DownloadFileActivity per file, at most maxParallelDownloads at a
+// time, so that each file is retried and timed out on its own and a lost worker only costs
+// the files it was downloading. No file starts after the deadline or once the workflow is
+// canceled. The progress reported for a file is dropped once its activity completed. It
+// returns the results in the order of files, and the ids of the files whose activity gave up.
+func downloadRound(ctx workflow.Context, requestID int, files []domain.FileRef, deadline time.Time, progress map[int]domain.FileProgress) ([]string, []int) {
+	var a *Activities
+	results := make([]string, len(files))
+	var gaveUp []int
+
+	selector := workflow.NewSelector(ctx)
+	next, running := 0, 0
+	for {
+		for running < maxParallelDownloads && next < len(files) && ctx.Err() == nil && workflow.Now(ctx).Before(deadline) {
+			index, file := next, files[next]
+			next++
+			running++
+			future := workflow.ExecuteActivity(ctx, a.DownloadFileActivity, requestID, file, deadline)
+			selector.AddFuture(future, func(f workflow.Future) {
+				running--
+				delete(progress, file.ID)
+				if err := f.Get(ctx, &results[index]); err != nil && !temporal.IsCanceledError(err) {
+					workflow.GetLogger(ctx).Error("Failed to download file", "RequestID", requestID, "FileID", file.ID, "Error", err)
+					gaveUp = append(gaveUp, file.ID)
+				}
+			})
+		}
+		if running == 0 {
+			return results, gaveUp
+		}
+		selector.Select(ctx)
+	}
+}
+
+// receiveAddedFiles takes the files of every AddFilesSignal received so far.
+func receiveAddedFiles(added workflow.ReceiveChannel) []domain.FileRef {
+	var files []domain.FileRef
+	for {
+		var batch []domain.FileRef
+		if !added.ReceiveAsync(&batch) {
+			return files
+		}
+		files = append(files, batch...)
+	}
+}
+
+// finalize runs an activity that records how a request ended. It runs in a
+// disconnected context so that it also happens when the workflow is canceled.
+func finalize(ctx workflow.Context, requestID int, recordActivity interface{}, failure string) {
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	if err := workflow.ExecuteActivity(ctx, recordActivity, requestID).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Error(failure, "RequestID", requestID, "Error", err)
+	}
+}
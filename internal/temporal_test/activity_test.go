@@ -0,0 +1,679 @@
+package temporal_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/blobstore"
+	"async-file-storage/internal/codec"
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
+	"async-file-storage/internal/temporal"
+	"async-file-storage/internal/webhook"
+
+	sdktemporal "go.temporal.io/sdk/temporal"
+)
+
+type fileUpdate struct {
+	state domain.FileState
+	key   string
+	size  int64
+	hash  string
+	enc   domain.ContentEncoding
+	dk    domain.DataKey
+	err   string
+	meta  domain.FileMeta
+}
+
+type fakeStorage struct {
+	mu         sync.Mutex
+	updates    map[int]fileUpdate
+	contents   map[string]string
+	status     domain.Status
+	callback   string
+	deliveries []domain.WebhookDelivery
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{updates: make(map[int]fileUpdate), contents: make(map[string]string)}
+}
+
+func (f *fakeStorage) CreateRequest(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+	return 1, fileRefs(req.URLs), nil
+}
+
+func (f *fakeStorage) UpdateRequestStatus(ctx context.Context, id int, status domain.Status) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+	return nil
+}
+
+func (f *fakeStorage) UpdateFileStatus(ctx context.Context, requestID, fileID int, state domain.FileState, meta domain.FileMeta, downloadErr error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := fileUpdate{state: state, meta: meta}
+	if downloadErr != nil {
+		u.err = downloadErr.Error()
+	}
+	f.updates[fileID] = u
+	return nil
+}
+
+func (f *fakeStorage) SaveFileContent(ctx context.Context, requestID, fileID int, content domain.StoredContent, meta domain.FileMeta) (string, []string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := f.contents[content.Hash]
+	if !ok {
+		key = content.StorageKey
+		f.contents[content.Hash] = key
+	}
+	f.updates[fileID] = fileUpdate{
+		state: domain.FileSucceeded, key: key, size: content.Size, hash: content.Hash, enc: content.Encoding, dk: content.DataKey, meta: meta,
+	}
+	return key, nil, nil
+}
+
+func (f *fakeStorage) RecordProgress(ctx context.Context, requestID int, progress []domain.FileProgress) error {
+	return nil
+}
+
+func (f *fakeStorage) RecordDelivery(ctx context.Context, d domain.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deliveries = append(f.deliveries, d)
+	return nil
+}
+
+func (f *fakeStorage) GetRequestStatus(ctx context.Context, id int) (*domain.DownloadRequest, []domain.FileEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var files []domain.FileEntry
+	for fileID, u := range f.updates {
+		files = append(files, domain.FileEntry{ID: fileID, RequestID: id, State: u.state, Error: u.err, FileMeta: u.meta})
+	}
+	return &domain.DownloadRequest{ID: id, Status: f.status, CallbackURL: f.callback}, files, nil
+}
+
+func (f *fakeStorage) ListExpiredRequests(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) PurgeRequest(ctx context.Context, id int) ([]string, error) {
+	return nil, domain.ErrNotFound
+}
+
+// fileRefs numbers the files of a request from 1, in order.
+func fileRefs(urls []string) []domain.FileRef {
+	refs := make([]domain.FileRef, len(urls))
+	for i, url := range urls {
+		refs[i] = domain.FileRef{ID: i + 1, URL: url}
+	}
+	return refs
+}
+
+// downloadFiles downloads files as a round of DownloadWorkflow does, one after the other,
+// and records the request status.
+func downloadFiles(t *testing.T, a *temporal.Activities, files []domain.FileRef) {
+	t.Helper()
+	ctx := context.Background()
+	deadline := time.Now().Add(10 * time.Second)
+	for _, file := range files {
+		if _, err := a.DownloadFileActivity(ctx, 1, file, deadline); err != nil {
+			t.Fatalf("download %s: %v", file.URL, err)
+		}
+	}
+	if err := a.FinishRoundActivity(ctx, 1, files, nil); err != nil {
+		t.Fatalf("finish round: %v", err)
+	}
+}
+
+func TestDownloadFileActivity_StreamsAndEnforcesMaxSize(t *testing.T) {
+	body := strings.Repeat("x", 64<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			_, _ = w.Write([]byte("hello"))
+		case "/declared":
+			_, _ = w.Write([]byte(body))
+		case "/chunked":
+			// Flushing forces chunked encoding, so the size is unknown up front.
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte(body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs, MaxFileSize: 1024}
+
+	urls := []string{srv.URL + "/small", srv.URL + "/declared", srv.URL + "/chunked", srv.URL + "/missing"}
+	downloadFiles(t, a, fileRefs(urls))
+
+	small := repo.updates[1]
+	if small.state != domain.FileSucceeded || small.err != "" || small.size != 5 || small.key == "" {
+		t.Fatalf("unexpected result for small file: %+v", small)
+	}
+	for i := 1; i < 3; i++ {
+		if got := repo.updates[i+1]; got.state != domain.FileFailed || got.err != "TOO_LARGE" {
+			t.Fatalf("expected a FAILED file with TOO_LARGE for %s, got %+v", urls[i], got)
+		}
+	}
+	if got := repo.updates[4]; got.state != domain.FileFailed || got.err != "DOWNLOAD_FAILED" {
+		t.Fatalf("expected a FAILED file with DOWNLOAD_FAILED for missing file, got %+v", got)
+	}
+	if repo.status != domain.StatusPartial {
+		t.Fatalf("expected request status PARTIAL, got %s", repo.status)
+	}
+}
+
+func TestFinishRoundActivity_RecordsFilesLeftBehind(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+	files := fileRefs([]string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c", srv.URL + "/d"})
+	repo.updates[2] = fileUpdate{state: domain.FilePending}
+	repo.updates[3] = fileUpdate{state: domain.FilePending}
+	repo.updates[4] = fileUpdate{state: domain.FileSucceeded}
+
+	// The first download runs into the deadline, the second would start after it.
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for _, file := range files[:2] {
+		if _, err := a.DownloadFileActivity(context.Background(), 1, file, deadline); err != nil {
+			t.Fatalf("download %s: %v", file.URL, err)
+		}
+	}
+	if got := repo.updates[1]; got.state != domain.FileDownloading {
+		t.Fatalf("expected the cut off file to be left to the round, got %+v", got)
+	}
+
+	// The activity of the third file gave up.
+	if err := a.FinishRoundActivity(context.Background(), 1, files, []int{3}); err != nil {
+		t.Fatalf("finish round: %v", err)
+	}
+	if got := repo.updates[1]; got.state != domain.FileFailed || got.err != "TIMEOUT" || got.meta.StartedAt == nil || got.meta.FinishedAt == nil {
+		t.Fatalf("expected a FAILED file with TIMEOUT and its timings, got %+v", got)
+	}
+	if got := repo.updates[2]; got.state != domain.FileSkipped || got.err != "TIMEOUT" {
+		t.Fatalf("expected a SKIPPED file with TIMEOUT, got %+v", got)
+	}
+	if got := repo.updates[3]; got.state != domain.FileFailed || got.err != "WORKFLOW_FAILED" {
+		t.Fatalf("expected a FAILED file with WORKFLOW_FAILED, got %+v", got)
+	}
+	if got := repo.updates[4]; got.state != domain.FileSucceeded {
+		t.Fatalf("expected the downloaded file to stay SUCCEEDED, got %+v", got)
+	}
+	if repo.status != domain.StatusPartial {
+		t.Fatalf("expected request status PARTIAL, got %s", repo.status)
+	}
+}
+
+func TestDownloadFilesActivity_TimeoutFailsStartedAndSkipsTheRest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+	repo.updates[4] = fileUpdate{state: domain.FilePending}
+
+	// Three downloads run at a time, so the fourth is never started.
+	urls := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c", srv.URL + "/d"}
+	if _, err := a.DownloadFilesActivity(context.Background(), 1, fileRefs(urls), 200*time.Millisecond); err != nil {
+		t.Fatalf("activity: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := repo.updates[i+1]; got.state != domain.FileFailed || got.err != "TIMEOUT" || got.meta.StartedAt == nil {
+			t.Fatalf("expected a FAILED file with TIMEOUT for %s, got %+v", urls[i], got)
+		}
+	}
+	if got := repo.updates[4]; got.state != domain.FileSkipped || got.err != "TIMEOUT" {
+		t.Fatalf("expected a SKIPPED file with TIMEOUT for the last file, got %+v", got)
+	}
+	if repo.status != domain.StatusError {
+		t.Fatalf("expected request status ERROR, got %s", repo.status)
+	}
+}
+
+func TestDownloadFilesActivity_CancelStartsNoMoreFiles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+
+	// Three downloads run at a time; canceling frees their slots, which the rest must not take.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	urls := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c", srv.URL + "/d", srv.URL + "/e"}
+	if _, err := a.DownloadFilesActivity(ctx, 1, fileRefs(urls), time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := repo.updates[i+1]; got.state != domain.FileDownloading {
+			t.Fatalf("expected %s to be left DOWNLOADING, got %+v", urls[i], got)
+		}
+	}
+	for i := 3; i < len(urls); i++ {
+		if got, ok := repo.updates[i+1]; ok {
+			t.Fatalf("expected %s never to start, got %+v", urls[i], got)
+		}
+	}
+}
+
+func TestDownloadFileActivity_CancelLeavesFileToCancelRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	file := domain.FileRef{ID: 1, URL: srv.URL + "/a"}
+	if _, err := a.DownloadFileActivity(ctx, 1, file, time.Now().Add(time.Minute)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if got := repo.updates[1]; got.state != domain.FileDownloading || repo.status != "" {
+		t.Fatalf("expected the activity to leave the file and request alone, got %+v and %q", got, repo.status)
+	}
+
+	if err := a.CancelRequestActivity(context.Background(), 1); err != nil {
+		t.Fatalf("cancel request: %v", err)
+	}
+	got := repo.updates[1]
+	if got.state != domain.FileCanceled || got.err != "CANCELED" || got.meta.StartedAt == nil || got.meta.FinishedAt == nil {
+		t.Fatalf("expected a CANCELED file with its start and end, got %+v", got)
+	}
+	if repo.status != domain.StatusCanceled {
+		t.Fatalf("expected request status CANCELED, got %s", repo.status)
+	}
+}
+
+func TestCancelRequestActivity_KeepsFinishedFiles(t *testing.T) {
+	repo := newFakeStorage()
+	repo.updates[1] = fileUpdate{state: domain.FileSucceeded}
+	repo.updates[2] = fileUpdate{state: domain.FilePending}
+	a := &temporal.Activities{Repo: repo}
+
+	if err := a.CancelRequestActivity(context.Background(), 1); err != nil {
+		t.Fatalf("cancel request: %v", err)
+	}
+	if got := repo.updates[1]; got.state != domain.FileSucceeded {
+		t.Fatalf("expected the downloaded file to stay SUCCEEDED, got %+v", got)
+	}
+	if got := repo.updates[2]; got.state != domain.FileCanceled || got.meta.FinishedAt != nil {
+		t.Fatalf("expected an untouched CANCELED file, got %+v", got)
+	}
+}
+
+func TestDeliverWebhookActivity_SignsAndRecordsAttempts(t *testing.T) {
+	const secret = "s3cret"
+	var (
+		mu     sync.Mutex
+		bodies [][]byte
+		status = http.StatusServiceUnavailable
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign([]byte(secret), timestamp, body) {
+			t.Errorf("signature %q does not match the body", r.Header.Get(webhook.SignatureHeader))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	respond := func(code int) {
+		mu.Lock()
+		defer mu.Unlock()
+		status = code
+	}
+	defer srv.Close()
+
+	repo := newFakeStorage()
+	repo.status = domain.StatusDone
+	repo.callback = srv.URL + "/done"
+	repo.updates[1] = fileUpdate{state: domain.FileSucceeded}
+	a := &temporal.Activities{Repo: repo, Webhooks: webhook.NewSender(secret)}
+
+	err := a.DeliverWebhookActivity(context.Background(), 1)
+	var appErr *sdktemporal.ApplicationError
+	if err == nil || errors.As(err, &appErr) {
+		t.Fatalf("expected a retryable error for HTTP 503, got %v", err)
+	}
+
+	respond(http.StatusGone)
+	err = a.DeliverWebhookActivity(context.Background(), 1)
+	if !errors.As(err, &appErr) || !appErr.NonRetryable() {
+		t.Fatalf("expected a non-retryable error for HTTP 410, got %v", err)
+	}
+
+	respond(http.StatusNoContent)
+	if err := a.DeliverWebhookActivity(context.Background(), 1); err != nil {
+		t.Fatalf("deliver webhook: %v", err)
+	}
+
+	if len(repo.deliveries) != 3 {
+		t.Fatalf("expected three recorded deliveries, got %+v", repo.deliveries)
+	}
+	for i, want := range []struct {
+		status     domain.DeliveryStatus
+		httpStatus int
+	}{{domain.DeliveryFailed, 503}, {domain.DeliveryFailed, 410}, {domain.DeliverySucceeded, 204}} {
+		d := repo.deliveries[i]
+		if d.Status != want.status || d.HTTPStatus != want.httpStatus || d.URL != repo.callback || d.RequestID != 1 {
+			t.Fatalf("delivery %d: expected %s with HTTP %d, got %+v", i, want.status, want.httpStatus, d)
+		}
+		if (d.Error != "") != (want.status == domain.DeliveryFailed) {
+			t.Fatalf("delivery %d: unexpected error %q", i, d.Error)
+		}
+	}
+	if !strings.Contains(string(bodies[2]), `"status":"DONE"`) {
+		t.Fatalf("expected the request status in the body, got %s", bodies[2])
+	}
+}
+
+func TestDeliverWebhookActivity_SkipsRequestsWithoutCallback(t *testing.T) {
+	repo := newFakeStorage()
+	repo.status = domain.StatusDone
+	a := &temporal.Activities{Repo: repo, Webhooks: webhook.NewSender("s3cret")}
+
+	if err := a.DeliverWebhookActivity(context.Background(), 1); err != nil {
+		t.Fatalf("deliver webhook: %v", err)
+	}
+	if len(repo.deliveries) != 0 {
+		t.Fatalf("expected no deliveries, got %+v", repo.deliveries)
+	}
+}
+
+func TestDownloadFileActivity_DeduplicatesContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("same release tarball"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	blobs, err := blobstore.NewFSStore(dir)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+
+	// The same URL listed twice is downloaded as two files.
+	urls := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/a"}
+	downloadFiles(t, a, fileRefs(urls))
+
+	first := repo.updates[1]
+	for id := 2; id <= len(urls); id++ {
+		if got := repo.updates[id]; first.key == "" || got.key != first.key {
+			t.Fatalf("expected all files to share one blob, got %q and %q", first.key, got.key)
+		}
+	}
+	sum := sha256.Sum256([]byte("same release tarball"))
+	if want := hex.EncodeToString(sum[:]); first.hash != want {
+		t.Fatalf("expected hash %s, got %q", want, first.hash)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "content"))
+	if err != nil {
+		t.Fatalf("read blob dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the duplicate upload to be deleted, found %d blobs", len(entries))
+	}
+}
+
+func TestDownloadFileActivity_RecordsMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest":
+			http.Redirect(w, r, "/v2/report", http.StatusFound)
+		case "/v2/report":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="../../report.pdf"`)
+			_, _ = w.Write([]byte("%PDF-1.7"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+
+	before := time.Now()
+	urls := []string{srv.URL + "/latest", srv.URL + "/missing"}
+	downloadFiles(t, a, fileRefs(urls))
+
+	meta := repo.updates[1].meta
+	if meta.ContentType != "application/pdf" || meta.FileName != "report.pdf" || meta.HTTPStatus != http.StatusOK {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	if meta.FinalURL != srv.URL+"/v2/report" {
+		t.Fatalf("expected the final URL after redirects, got %q", meta.FinalURL)
+	}
+	if meta.StartedAt == nil || meta.FinishedAt == nil || meta.StartedAt.Before(before) || meta.FinishedAt.Before(*meta.StartedAt) {
+		t.Fatalf("unexpected timings %v - %v", meta.StartedAt, meta.FinishedAt)
+	}
+
+	failed := repo.updates[2]
+	if failed.err != "DOWNLOAD_FAILED" || failed.meta.HTTPStatus != http.StatusNotFound || failed.meta.FinishedAt == nil {
+		t.Fatalf("expected the failed download to keep its status and timings, got %+v", failed)
+	}
+}
+
+func TestDownloadFileActivity_CompressesText(t *testing.T) {
+	text := strings.Repeat("2026-01-02T03:04:05Z INFO request served\n", 2000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Both bodies compress well; only the media type tells them apart.
+		if r.URL.Path == "/app.log" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		_, _ = w.Write([]byte(text + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	policy := codec.Policy{Encoding: domain.EncodingZstd, MinSize: 1024, ContentTypes: codec.DefaultContentTypes}
+	a := &temporal.Activities{Repo: repo, Blobs: blobs, Compression: policy}
+
+	urls := []string{srv.URL + "/app.log", srv.URL + "/image.png"}
+	downloadFiles(t, a, fileRefs(urls))
+
+	logFile, image := repo.updates[1], repo.updates[2]
+	want := text + "/app.log"
+	sum := sha256.Sum256([]byte(want))
+	if logFile.enc != domain.EncodingZstd || logFile.size != int64(len(want)) || logFile.hash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected zstd with the size and hash of the download, got %+v", logFile)
+	}
+	if image.enc != domain.EncodingIdentity {
+		t.Fatalf("expected the image to be stored as is, got %s", image.enc)
+	}
+
+	info, err := blobs.Stat(context.Background(), logFile.key)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size >= int64(len(text))/5 {
+		t.Fatalf("expected the stored log to be compressed, got %d bytes", info.Size)
+	}
+	stored, err := blobs.Get(context.Background(), logFile.key)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer stored.Close()
+	r, err := codec.NewReader(domain.EncodingZstd, stored)
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || string(data) != want {
+		t.Fatalf("stored content does not decode to the download: %v", err)
+	}
+}
+
+func TestDownloadFileActivity_EncryptsContent(t *testing.T) {
+	text := strings.Repeat("confidential,report,row\n", 4000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		_, _ = w.Write([]byte(text))
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	keys, err := envelope.ParseKeyring([]byte(`{"active":"k1","keys":{"k1":"` + strings.Repeat("A", 43) + `="}}`))
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	repo := newFakeStorage()
+	policy := codec.Policy{Encoding: domain.EncodingGzip, ContentTypes: codec.DefaultContentTypes}
+	a := &temporal.Activities{Repo: repo, Blobs: blobs, Compression: policy, Keys: keys}
+
+	downloadFiles(t, a, fileRefs([]string{srv.URL + "/report.csv"}))
+
+	u := repo.updates[1]
+	sum := sha256.Sum256([]byte(text))
+	if u.dk.MasterKeyID != "k1" || u.enc != domain.EncodingGzip || u.hash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected gzip content under key k1 with the hash of the download, got %+v", u)
+	}
+
+	info, err := blobs.Stat(context.Background(), u.key)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	stored, err := blobs.Get(context.Background(), u.key)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	raw, err := io.ReadAll(stored)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if strings.Contains(string(raw), "confidential") {
+		t.Fatal("stored content is readable without the key")
+	}
+
+	dataKey, err := keys.Unwrap(u.dk)
+	if err != nil {
+		t.Fatalf("unwrap: %v", err)
+	}
+	plain, err := envelope.NewReader(dataKey, stored, info.Size)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	defer plain.Close()
+	// Content is compressed before it is encrypted; ciphertext would not compress.
+	r, err := codec.NewReader(domain.EncodingGzip, plain)
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || string(data) != text {
+		t.Fatalf("stored content does not decrypt to the download: %v", err)
+	}
+}
+
+func TestDownloadFileActivity_VerifiesChecksums(t *testing.T) {
+	body := []byte("release-1.2.3.tar.gz contents")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	blobs, err := blobstore.NewFSStore(dir)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := newFakeStorage()
+	a := &temporal.Activities{Repo: repo, Blobs: blobs}
+
+	sha := sha256.Sum256(body)
+	md := md5.Sum(body)
+	files := []domain.FileRef{
+		{ID: 1, URL: srv.URL + "/good", Checksums: domain.Digests{SHA256: hex.EncodeToString(sha[:]), MD5: hex.EncodeToString(md[:])}},
+		{ID: 2, URL: srv.URL + "/bad", Checksums: domain.Digests{SHA512: strings.Repeat("0", 128)}},
+	}
+	downloadFiles(t, a, files)
+
+	good := repo.updates[1]
+	if good.state != domain.FileSucceeded || good.meta.Digests.MD5 != hex.EncodeToString(md[:]) || good.meta.Digests.SHA512 != "" {
+		t.Fatalf("expected a verified file with its computed digests, got %+v", good)
+	}
+
+	bad := repo.updates[2]
+	want := sha512.Sum512(body)
+	if bad.state != domain.FileFailed || bad.err != "CHECKSUM_MISMATCH" || bad.meta.Digests.SHA512 != hex.EncodeToString(want[:]) {
+		t.Fatalf("expected a checksum mismatch reporting the computed digest, got %+v", bad)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "content"))
+	if err != nil {
+		t.Fatalf("read blob dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the mismatched upload to be deleted, found %d blobs", len(entries))
+	}
+}
@@ -0,0 +1,149 @@
+package temporal_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/blobstore"
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/repository"
+	"async-file-storage/internal/temporal"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestRetentionWorkflow_StopsAfterAShortBatch(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	env.OnActivity(a.PurgeExpiredActivity, mock.Anything, 10).Return(10, nil).Twice()
+	env.OnActivity(a.PurgeExpiredActivity, mock.Anything, 10).Return(3, nil).Once()
+
+	env.ExecuteWorkflow(temporal.RetentionWorkflow, 10)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	var purged int
+	if err := env.GetWorkflowResult(&purged); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if purged != 23 {
+		t.Fatalf("expected 23 purged requests, got %d", purged)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestRetentionWorkflow_CapsTheBatchesOfARun(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	// Full batches keep coming; the run stops after 100 and leaves the rest to the next one.
+	env.OnActivity(a.PurgeExpiredActivity, mock.Anything, 10).Return(10, nil).Times(100)
+
+	env.ExecuteWorkflow(temporal.RetentionWorkflow, 10)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	var purged int
+	if err := env.GetWorkflowResult(&purged); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if purged != 1000 {
+		t.Fatalf("expected 1000 purged requests, got %d", purged)
+	}
+	env.AssertExpectations(t)
+}
+
+// deletedMeanwhile lists requests as expired that a user deleted before they were purged.
+type deletedMeanwhile struct {
+	*repository.MemoryRepository
+	deleted []int
+}
+
+func (r deletedMeanwhile) ListExpiredRequests(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	ids, err := r.MemoryRepository.ListExpiredRequests(ctx, now, limit)
+	return append(r.deleted, ids...), err
+}
+
+func TestPurgeExpiredActivity_TombstonesRequestsAndReleasesContent(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := blobstore.NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	repo := repository.NewMemoryRepository()
+	a := &temporal.Activities{Repo: deletedMeanwhile{MemoryRepository: repo, deleted: []int{999}}, Blobs: blobs}
+
+	past := time.Now().Add(-time.Hour)
+	create := func(status domain.Status, hash string) int {
+		t.Helper()
+		id, files, err := repo.CreateRequest(ctx, domain.NewRequest{URLs: []string{"https://example.com/" + hash}, ExpiresAt: &past})
+		if err != nil {
+			t.Fatalf("create request: %v", err)
+		}
+		key := "blob-" + hash
+		if _, err := blobs.Put(ctx, key, strings.NewReader(hash)); err != nil {
+			t.Fatalf("put blob: %v", err)
+		}
+		content := domain.StoredContent{Hash: hash, StorageKey: key, Size: int64(len(hash))}
+		if _, _, err := repo.SaveFileContent(ctx, id, files[0].ID, content, domain.FileMeta{}); err != nil {
+			t.Fatalf("save content: %v", err)
+		}
+		if err := repo.UpdateRequestStatus(ctx, id, status); err != nil {
+			t.Fatalf("update status: %v", err)
+		}
+		return id
+	}
+	exists := func(key string) bool {
+		t.Helper()
+		_, err := blobs.Stat(ctx, key)
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			t.Fatalf("stat %s: %v", key, err)
+		}
+		return err == nil
+	}
+
+	// The shared content is also referenced by a request that is still downloading.
+	own := create(domain.StatusDone, "own")
+	shared := create(domain.StatusDone, "shared")
+	running := create(domain.StatusProcess, "shared")
+
+	purged, err := a.PurgeExpiredActivity(ctx, 10)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if purged != 2 {
+		t.Fatalf("expected 2 purged requests, the deleted one skipped, got %d", purged)
+	}
+	for _, id := range []int{own, shared} {
+		if _, err := repo.GetRequest(ctx, id); !errors.Is(err, domain.ErrGone) {
+			t.Fatalf("expected request %d to be tombstoned, got %v", id, err)
+		}
+	}
+	if _, err := repo.GetRequest(ctx, running); err != nil {
+		t.Fatalf("expected the running request to stay, got %v", err)
+	}
+	if exists("blob-own") || !exists("blob-shared") {
+		t.Fatalf("expected only the content without references left to be deleted")
+	}
+
+	if err := repo.UpdateRequestStatus(ctx, running, domain.StatusDone); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	if purged, err := a.PurgeExpiredActivity(ctx, 10); err != nil || purged != 1 {
+		t.Fatalf("expected the finished request to be purged, got %d, %v", purged, err)
+	}
+	if exists("blob-shared") {
+		t.Fatalf("expected the shared content to be deleted with its last reference")
+	}
+}
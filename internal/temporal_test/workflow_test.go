@@ -0,0 +1,193 @@
+package temporal_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/temporal"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+func TestDownloadWorkflow_DownloadsAddedFiles(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	first := []domain.FileRef{{ID: 1, URL: "https://example.com/a"}}
+	added := []domain.FileRef{{ID: 2, URL: "https://example.com/b"}, {ID: 3, URL: "https://example.com/c"}}
+
+	// The signal arrives while the first round is still downloading.
+	env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, first[0], mock.Anything).
+		After(2*time.Minute).Return("a", nil).Once()
+	env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, added[0], mock.Anything).Return("b", nil).Once()
+	env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, added[1], mock.Anything).Return("c", nil).Once()
+	env.OnActivity(a.FinishRoundActivity, mock.Anything, 7, first, []int(nil)).Return(nil).Once()
+	env.OnActivity(a.FinishRoundActivity, mock.Anything, 7, added, []int(nil)).Return(nil).Once()
+	// The webhook goes out once, after the added files are downloaded too.
+	env.OnActivity(a.DeliverWebhookActivity, mock.Anything, 7).Return(nil).Once()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(temporal.AddFilesSignal, added[:1])
+		env.SignalWorkflow(temporal.AddFilesSignal, added[1:])
+	}, time.Minute)
+
+	env.ExecuteWorkflow(temporal.DownloadWorkflow, 7, first, time.Minute)
+
+	if !env.IsWorkflowCompleted() {
+		t.Fatal("expected the workflow to complete")
+	}
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	var results []string
+	if err := env.GetWorkflowResult(&results); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if !reflect.DeepEqual(results, []string{"a", "b", "c"}) {
+		t.Fatalf("expected results of both rounds, got %v", results)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestDownloadWorkflow_RetriesFilesOnTheirOwn(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	files := []domain.FileRef{{ID: 1, URL: "https://example.com/a"}, {ID: 2, URL: "https://example.com/b"}}
+	var attempts int
+	env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, files[0], mock.Anything).Return("a", nil).Once()
+	env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, files[1], mock.Anything).Return(
+		func(ctx context.Context, requestID int, file domain.FileRef, deadline time.Time) (string, error) {
+			if attempts++; attempts < 3 {
+				return "", errors.New("database is unavailable")
+			}
+			return "b", nil
+		})
+	env.OnActivity(a.FinishRoundActivity, mock.Anything, 7, files, []int(nil)).Return(nil).Once()
+	env.OnActivity(a.DeliverWebhookActivity, mock.Anything, 7).Return(nil).Once()
+
+	env.ExecuteWorkflow(temporal.DownloadWorkflow, 7, files, time.Minute)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected the second file to be downloaded three times, got %d", attempts)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestDownloadWorkflow_BoundsParallelismAndStopsAtTheDeadline(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	files := []domain.FileRef{
+		{ID: 1, URL: "https://example.com/a"}, {ID: 2, URL: "https://example.com/b"},
+		{ID: 3, URL: "https://example.com/c"}, {ID: 4, URL: "https://example.com/d"},
+		{ID: 5, URL: "https://example.com/e"},
+	}
+	// Three files start at once and the fourth when the first is done; by the time
+	// another slot frees up the deadline has passed, so the fifth never starts.
+	for i, after := range []time.Duration{20 * time.Second, 40 * time.Second, 40 * time.Second, 40 * time.Second} {
+		env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, files[i], mock.Anything).
+			After(after).Return(files[i].URL, nil).Once()
+	}
+	env.OnActivity(a.FinishRoundActivity, mock.Anything, 7, files, []int(nil)).Return(nil).Once()
+	env.OnActivity(a.DeliverWebhookActivity, mock.Anything, 7).Return(nil).Once()
+
+	env.ExecuteWorkflow(temporal.DownloadWorkflow, 7, files, 30*time.Second)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	var results []string
+	if err := env.GetWorkflowResult(&results); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	want := []string{files[0].URL, files[1].URL, files[2].URL, files[3].URL, ""}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("expected results %v, got %v", want, results)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestDownloadWorkflow_OlderRunsKeepTheBatchActivity(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	files := []domain.FileRef{{ID: 1, URL: "https://example.com/a"}, {ID: 2, URL: "https://example.com/b"}}
+	env.OnGetVersion("per-file-activities", workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	env.OnActivity(a.DownloadFilesActivity, mock.Anything, 7, files, time.Minute).Return([]string{"a", "b"}, nil).Once()
+	env.OnActivity(a.DeliverWebhookActivity, mock.Anything, 7).Return(nil).Once()
+
+	env.ExecuteWorkflow(temporal.DownloadWorkflow, 7, files, time.Minute)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	var results []string
+	if err := env.GetWorkflowResult(&results); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if !reflect.DeepEqual(results, []string{"a", "b"}) {
+		t.Fatalf("expected the results of the batch activity, got %v", results)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestDownloadWorkflow_AnswersProgressQuery(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	var a *temporal.Activities
+	env.RegisterActivity(a)
+
+	files := []domain.FileRef{{ID: 1, URL: "https://example.com/a"}}
+	env.OnActivity(a.DownloadFileActivity, mock.Anything, 7, files[0], mock.Anything).
+		After(2*time.Minute).Return("a", nil)
+	env.OnActivity(a.FinishRoundActivity, mock.Anything, 7, files, []int(nil)).Return(nil)
+	env.OnActivity(a.DeliverWebhookActivity, mock.Anything, 7).Return(nil)
+
+	report := []domain.FileProgress{{FileID: 1, BytesDownloaded: 10, BytesTotal: 40, StartedAt: time.Unix(0, 0).UTC()}}
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(temporal.ProgressSignal, report)
+	}, time.Minute)
+	query := func() []domain.FileProgress {
+		value, err := env.QueryWorkflow(temporal.ProgressQuery)
+		if err != nil {
+			t.Errorf("query: %v", err)
+			return nil
+		}
+		var got []domain.FileProgress
+		if err := value.Get(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		return got
+	}
+	var during []domain.FileProgress
+	env.RegisterDelayedCallback(func() { during = query() }, 90*time.Second)
+
+	env.ExecuteWorkflow(temporal.DownloadWorkflow, 7, files, time.Minute)
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow: %v", err)
+	}
+	if len(during) != 1 || during[0] != report[0] {
+		t.Fatalf("expected the query to return %+v, got %+v", report, during)
+	}
+	if after := query(); len(after) != 0 {
+		t.Fatalf("expected no progress once the file finished, got %+v", after)
+	}
+}
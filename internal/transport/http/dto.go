@@ -1,29 +1,93 @@
 package httptransport
 
+import "time"
+
 type createRequestBody struct {
-	Files   []fileInput `json:"files"`
-	Timeout string      `json:"timeout"`
+	Files       []fileInput `json:"files"`
+	Timeout     string      `json:"timeout"`
+	ExpiresAt   *time.Time  `json:"expires_at,omitempty"`
+	CallbackURL string      `json:"callback_url,omitempty"`
 }
 
 type fileInput struct {
 	URL string `json:"url"`
+	// Optional hex digests the downloaded content must match.
+	SHA256 string `json:"sha256,omitempty"`
+	SHA512 string `json:"sha512,omitempty"`
+	MD5    string `json:"md5,omitempty"`
 }
 
 type createResponse struct {
-	ID     int    `json:"id"`
-	Status string `json:"status"`
+	ID        int        `json:"id"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type addFilesBody struct {
+	Files []fileInput `json:"files"`
+	// Reopen allows adding files to a finished request.
+	Reopen bool `json:"reopen,omitempty"`
+}
+
+type addFilesResponse struct {
+	ID     int         `json:"id"`
+	Status string      `json:"status"`
+	Files  []addedFile `json:"files"`
+}
+
+type addedFile struct {
+	URL string `json:"url"`
+	ID  int    `json:"file_id"`
+}
+
+type retryRequestBody struct {
+	FileIDs []int  `json:"file_ids,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type retryResponse struct {
+	ID      int    `json:"id"`
+	Status  string `json:"status"`
+	FileIDs []int  `json:"file_ids"`
+}
+
+type listResponse struct {
+	Requests   []requestSummary `json:"requests"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type requestSummary struct {
+	ID        int        `json:"id"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type deliveriesResponse struct {
+	CallbackURL string     `json:"callback_url,omitempty"`
+	Deliveries  []delivery `json:"deliveries"`
 }
 
-type getRequestResponse struct {
-	ID     int           `json:"id"`
-	Status string        `json:"status"`
-	Files  []fileOutcome `json:"files"`
+type delivery struct {
+	ID         int64      `json:"id"`
+	URL        string     `json:"url"`
+	Attempt    int        `json:"attempt"`
+	Status     string     `json:"status"`
+	HTTPStatus int        `json:"http_status,omitempty"`
+	DurationMS int64      `json:"duration_ms"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Error      *errorInfo `json:"error,omitempty"`
 }
 
-type fileOutcome struct {
-	URL   string     `json:"url"`
-	ID    int        `json:"file_id,omitempty"`
-	Error *errorInfo `json:"error,omitempty"`
+type eventData struct {
+	RequestID       int        `json:"request_id"`
+	FileID          int        `json:"file_id,omitempty"`
+	State           string     `json:"state,omitempty"`
+	Status          string     `json:"status,omitempty"`
+	BytesDownloaded *int64     `json:"bytes_downloaded,omitempty"`
+	BytesTotal      *int64     `json:"bytes_total,omitempty"`
+	Error           *errorInfo `json:"error,omitempty"`
+	Time            time.Time  `json:"time"`
 }
 
 type errorInfo struct {
@@ -3,11 +3,17 @@ package httptransport
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/statusview"
 	"async-file-storage/internal/usecase"
 )
 
@@ -24,9 +30,13 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(path, "/")
 
 	if len(parts) == 1 && parts[0] == "downloads" {
-		if r.Method == http.MethodPost {
+		switch r.Method {
+		case http.MethodPost:
 			h.handleCreate(w, r)
 			return
+		case http.MethodGet:
+			h.handleList(w, r)
+			return
 		}
 		// TODO: дублируешь METHOD_NOW_ALLOWED, можно переделать writeError
 		// и такие вспомогательные функции можно вынести в отдельный файл helpers/utils
@@ -35,16 +45,74 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(parts) == 2 && parts[0] == "downloads" {
-		if r.Method == http.MethodGet {
+		switch r.Method {
+		case http.MethodGet:
 			h.handleGet(w, r, parts[1])
 			return
+		case http.MethodDelete:
+			h.handleDelete(w, r, parts[1])
+			return
 		}
 		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
 		return
 	}
 
-	if len(parts) == 4 && parts[0] == "downloads" && parts[2] == "files" {
+	if len(parts) == 3 && parts[0] == "downloads" && parts[2] == "files" {
+		if r.Method == http.MethodPost {
+			h.handleAddFiles(w, r, parts[1])
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "downloads" && parts[2] == "cancel" {
+		if r.Method == http.MethodPost {
+			h.handleCancel(w, r, parts[1])
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "downloads" && parts[2] == "retry" {
+		if r.Method == http.MethodPost {
+			h.handleRetry(w, r, parts[1])
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "downloads" && parts[2] == "events" {
 		if r.Method == http.MethodGet {
+			h.handleEvents(w, r, parts[1])
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "downloads" && parts[2] == "deliveries" {
+		if r.Method == http.MethodGet {
+			h.handleDeliveries(w, r, parts[1])
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "downloads" && parts[2] == "archive" {
+		if r.Method == http.MethodGet {
+			h.handleArchive(w, r, parts[1])
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+
+	if len(parts) == 4 && parts[0] == "downloads" && parts[2] == "files" {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
 			h.handleGetFile(w, r, parts[1], parts[3])
 			return
 		}
@@ -62,10 +130,7 @@ func (h *Handler) handleCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urls := make([]string, 0, len(body.Files))
-	for _, f := range body.Files {
-		urls = append(urls, f.URL)
-	}
+	urls, checksums := splitFiles(body.Files)
 
 	timeout, err := time.ParseDuration(body.Timeout)
 	if err != nil {
@@ -74,45 +139,188 @@ func (h *Handler) handleCreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	out, err := h.service.CreateRequest(r.Context(), usecase.CreateRequestInput{
-		URLs:    urls,
-		Timeout: timeout,
+		URLs:      urls,
+		Checksums: checksums,
+		Timeout:   timeout,
+		ExpiresAt: body.ExpiresAt,
+
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		CallbackURL:    body.CallbackURL,
 	})
 	if err != nil {
 		writeUsecaseError(w, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, createResponse{ID: out.ID, Status: string(out.Status)})
+	writeJSON(w, http.StatusOK, createResponse{ID: out.ID, Status: string(out.Status), ExpiresAt: out.ExpiresAt})
 }
 
-func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request, idValue string) {
+// splitFiles separates the URLs of files from their expected checksums. The checksums
+// are nil when no file has any.
+func splitFiles(files []fileInput) ([]string, []domain.Digests) {
+	urls := make([]string, 0, len(files))
+	var checksums []domain.Digests
+	for i, f := range files {
+		urls = append(urls, f.URL)
+		if f.SHA256 != "" || f.SHA512 != "" || f.MD5 != "" {
+			if checksums == nil {
+				checksums = make([]domain.Digests, len(files))
+			}
+			checksums[i] = domain.Digests{SHA256: f.SHA256, SHA512: f.SHA512, MD5: f.MD5}
+		}
+	}
+	return urls, checksums
+}
+
+func (h *Handler) handleAddFiles(w http.ResponseWriter, r *http.Request, idValue string) {
 	id, err := strconv.Atoi(idValue)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
 		return
 	}
 
-	out, err := h.service.GetRequest(r.Context(), id)
+	var body addFilesBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_JSON", "invalid json")
+		return
+	}
+	urls, checksums := splitFiles(body.Files)
+
+	out, err := h.service.AddFiles(r.Context(), usecase.AddFilesInput{
+		ID:        id,
+		URLs:      urls,
+		Checksums: checksums,
+		Reopen:    body.Reopen,
+	})
 	if err != nil {
 		writeUsecaseError(w, err)
 		return
 	}
 
-	resp := getRequestResponse{ID: out.ID, Status: string(out.Status)}
-	resp.Files = make([]fileOutcome, 0, len(out.Files))
+	resp := addFilesResponse{ID: out.ID, Status: string(out.Status), Files: make([]addedFile, 0, len(out.Files))}
 	for _, f := range out.Files {
-		item := fileOutcome{URL: f.URL}
-		if f.ErrorCode != "" {
-			item.Error = &errorInfo{Code: f.ErrorCode}
-		} else {
-			item.ID = f.FileID
+		resp.Files = append(resp.Files, addedFile{URL: f.URL, ID: f.ID})
+	}
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	input := usecase.ListRequestsInput{
+		Status:      domain.Status(q.Get("status")),
+		URLContains: q.Get("url"),
+		Host:        q.Get("host"),
+		Sort:        q.Get("sort"),
+		Cursor:      q.Get("cursor"),
+	}
+
+	var err error
+	if input.CreatedFrom, err = parseTimeParam(q.Get("created_from")); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_INPUT", "created_from must be an RFC 3339 time")
+		return
+	}
+	if input.CreatedTo, err = parseTimeParam(q.Get("created_to")); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_INPUT", "created_to must be an RFC 3339 time")
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if input.Limit, err = strconv.Atoi(v); err != nil || input.Limit <= 0 {
+			writeError(w, http.StatusBadRequest, "INVALID_INPUT", "limit must be a positive integer")
+			return
 		}
-		resp.Files = append(resp.Files, item)
 	}
 
+	out, err := h.service.ListRequests(r.Context(), input)
+	if err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+
+	resp := listResponse{Requests: make([]requestSummary, 0, len(out.Requests)), NextCursor: out.NextCursor}
+	for _, req := range out.Requests {
+		resp.Requests = append(resp.Requests, requestSummary{
+			ID:        req.ID,
+			Status:    string(req.Status),
+			CreatedAt: req.CreatedAt,
+			ExpiresAt: req.ExpiresAt,
+		})
+	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+
+	out, err := h.service.GetRequest(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statusview.New(out))
+}
+
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+
+	if err := h.service.DeleteRequest(r.Context(), id); err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleCancel(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+
+	if err := h.service.CancelRequest(r.Context(), id); err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) handleRetry(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+
+	// The body is optional: without one every failed file is retried.
+	var body retryRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "INVALID_JSON", "invalid json")
+		return
+	}
+	input := usecase.RetryRequestInput{ID: id, FileIDs: body.FileIDs}
+	if body.Timeout != "" {
+		if input.Timeout, err = time.ParseDuration(body.Timeout); err != nil || input.Timeout <= 0 {
+			writeError(w, http.StatusBadRequest, "INVALID_TIMEOUT", "invalid timeout")
+			return
+		}
+	}
+
+	out, err := h.service.RetryRequest(r.Context(), input)
+	if err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, retryResponse{ID: out.ID, Status: string(out.Status), FileIDs: out.FileIDs})
+}
+
 func (h *Handler) handleGetFile(w http.ResponseWriter, r *http.Request, requestIDValue string, fileIDValue string) {
 	requestID, err := strconv.Atoi(requestIDValue)
 	if err != nil {
@@ -125,7 +333,7 @@ func (h *Handler) handleGetFile(w http.ResponseWriter, r *http.Request, requestI
 		return
 	}
 
-	out, err := h.service.GetFile(r.Context(), requestID, fileID)
+	out, err := h.service.GetFile(r.Context(), requestID, fileID, acceptedEncodings(r.Header.Get("Accept-Encoding")))
 	if err != nil {
 		if bErr := (usecase.BusinessError{}); errors.As(err, &bErr) {
 			writeJSON(w, http.StatusOK, errorResponse{Error: errorInfo{Code: bErr.Code, Message: bErr.Msg}})
@@ -135,9 +343,148 @@ func (h *Handler) handleGetFile(w http.ResponseWriter, r *http.Request, requestI
 		return
 	}
 
+	defer func() { _ = out.Content.Close() }()
+
+	// ServeContent answers Range, If-Range, If-None-Match, If-Modified-Since and HEAD for us.
 	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", out.ETag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if out.Encoding != domain.EncodingIdentity {
+		w.Header().Set("Content-Encoding", string(out.Encoding))
+	}
+	http.ServeContent(w, r, "", out.ModTime, out.Content)
+}
+
+func (h *Handler) handleArchive(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+
+	archive, err := h.service.OpenArchive(r.Context(), id, r.URL.Query().Get("format"))
+	if err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", archive.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive.FileName}))
+	w.WriteHeader(http.StatusOK)
+	if err := archive.Stream(r.Context(), w); err != nil {
+		// The status is already sent; the client is left with a truncated archive,
+		// which fails to open.
+		log.Printf("stream archive of request %d: %v", id, err)
+	}
+}
+
+func (h *Handler) handleDeliveries(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+
+	out, err := h.service.ListDeliveries(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+
+	resp := deliveriesResponse{CallbackURL: out.CallbackURL, Deliveries: make([]delivery, 0, len(out.Deliveries))}
+	for _, d := range out.Deliveries {
+		item := delivery{
+			ID:         d.ID,
+			URL:        d.URL,
+			Attempt:    d.Attempt,
+			Status:     string(d.Status),
+			HTTPStatus: d.HTTPStatus,
+			DurationMS: d.Duration.Milliseconds(),
+			CreatedAt:  d.CreatedAt,
+		}
+		if d.Error != "" {
+			item.Error = &errorInfo{Code: "DELIVERY_FAILED", Message: d.Error}
+		}
+		resp.Deliveries = append(resp.Deliveries, item)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleEvents streams the events of a request as server-sent events. A client that
+// reconnects with Last-Event-ID continues behind that event.
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request, idValue string) {
+	id, err := strconv.Atoi(idValue)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ID", "invalid id")
+		return
+	}
+	var lastEventID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if lastEventID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_LAST_EVENT_ID", "invalid Last-Event-ID")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "streaming unsupported")
+		return
+	}
+
+	stream, err := h.service.OpenEventStream(r.Context(), id, lastEventID)
+	if err != nil {
+		writeUsecaseError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps reverse proxies such as nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(out.Data)
+	flusher.Flush()
+
+	err = stream.Stream(r.Context(), func(events []domain.Event) error {
+		if len(events) == 0 {
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return err
+			}
+		}
+		for _, e := range events {
+			data, err := json.Marshal(newEventData(e))
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return err
+			}
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && !errors.Is(err, usecase.ErrNotFound) && !errors.Is(err, usecase.ErrGone) {
+		log.Printf("stream events of request %d: %v", id, err)
+	}
+}
+
+func newEventData(e domain.Event) eventData {
+	data := eventData{RequestID: e.RequestID, Time: e.CreatedAt}
+	switch e.Type {
+	case domain.EventRequestCompleted:
+		data.Status = string(e.Status)
+		return data
+	case domain.EventFileProgress, domain.EventFileSucceeded:
+		data.BytesDownloaded = &e.BytesDownloaded
+		if e.BytesTotal > 0 {
+			data.BytesTotal = &e.BytesTotal
+		}
+	}
+	data.FileID = e.FileID
+	data.State = string(e.State)
+	if e.Error != "" {
+		data.Error = &errorInfo{Code: e.Error}
+	}
+	return data
 }
 
 // TODO: можно функции ниже вынести в отдельный файл
@@ -147,11 +494,54 @@ func writeUsecaseError(w http.ResponseWriter, err error) {
 		writeError(w, http.StatusBadRequest, "INVALID_INPUT", err.Error())
 	case errors.Is(err, usecase.ErrNotFound):
 		writeError(w, http.StatusNotFound, "NOT_FOUND", err.Error())
+	case errors.Is(err, usecase.ErrConflict):
+		writeError(w, http.StatusConflict, "CONFLICT", err.Error())
+	case errors.Is(err, usecase.ErrGone):
+		writeError(w, http.StatusGone, "GONE", err.Error())
 	default:
 		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "internal error")
 	}
 }
 
+// acceptedEncodings lists the stored encodings an Accept-Encoding header allows.
+// A coding with q=0 is refused even when "*" is present.
+func acceptedEncodings(header string) []domain.ContentEncoding {
+	allowed := make(map[domain.ContentEncoding]bool)
+	wildcard := false
+	for _, item := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			weight, err := strconv.ParseFloat(q, 64)
+			ok = err == nil && weight > 0
+		}
+		if enc := domain.ContentEncoding(strings.ToLower(strings.TrimSpace(coding))); enc == "*" {
+			wildcard = ok
+		} else {
+			allowed[enc] = ok
+		}
+	}
+
+	var accepted []domain.ContentEncoding
+	for _, enc := range []domain.ContentEncoding{domain.EncodingZstd, domain.EncodingGzip} {
+		if ok, listed := allowed[enc]; ok || (!listed && wildcard) {
+			accepted = append(accepted, enc)
+		}
+	}
+	return accepted
+}
+
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
 func writeError(w http.ResponseWriter, status int, code string, msg string) {
 	writeJSON(w, status, errorResponse{Error: errorInfo{Code: code, Message: msg}})
 }
@@ -0,0 +1,428 @@
+package httptransport_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"async-file-storage/internal/domain"
+	httptransport "async-file-storage/internal/transport/http"
+	"async-file-storage/internal/usecase"
+)
+
+const fileContent = "0123456789abcdefghij"
+
+type stubRepo struct{}
+
+func (stubRepo) CreateRequest(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+	return 0, nil, nil
+}
+
+func (stubRepo) GetRequest(ctx context.Context, id int) (*domain.DownloadRequest, error) {
+	if id != 1 {
+		return nil, domain.ErrNotFound
+	}
+	return &domain.DownloadRequest{ID: id, Status: domain.StatusDone}, nil
+}
+
+func (stubRepo) GetRequestStatus(ctx context.Context, id int) (*domain.DownloadRequest, []domain.FileEntry, error) {
+	if id != 1 {
+		return nil, nil, domain.ErrNotFound
+	}
+	files := []domain.FileEntry{
+		{ID: 2, RequestID: 1, URL: "https://example.com/a", StorageKey: "requests/1/a", Size: int64(len(fileContent)),
+			State: domain.FileSucceeded, FileMeta: domain.FileMeta{FileName: "report.csv"}},
+		{ID: 3, RequestID: 1, URL: "https://mirror.example.com/export/report.csv", StorageKey: "requests/1/gz",
+			Size: int64(len(fileContent)), Encoding: domain.EncodingGzip, State: domain.FileSucceeded},
+		{ID: 4, RequestID: 1, URL: "https://example.com/missing", State: domain.FileFailed, Error: "HTTP_404"},
+	}
+	return &domain.DownloadRequest{ID: id, Status: domain.StatusPartial}, files, nil
+}
+
+func (stubRepo) ListRequests(ctx context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error) {
+	return nil, nil
+}
+
+func (stubRepo) GetFile(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
+	switch {
+	case requestID == 1 && fileID == 2:
+		return &domain.FileEntry{ID: fileID, RequestID: requestID, StorageKey: "requests/1/a", Size: int64(len(fileContent))}, nil
+	case requestID == 1 && fileID == 3:
+		return &domain.FileEntry{
+			ID: fileID, RequestID: requestID, StorageKey: "requests/1/gz", Size: int64(len(fileContent)), Encoding: domain.EncodingGzip,
+		}, nil
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (stubRepo) DeleteRequest(ctx context.Context, id int) ([]string, error) {
+	return nil, domain.ErrNotFound
+}
+
+func (stubRepo) AppendFiles(ctx context.Context, requestID int, files domain.NewRequest, reopen bool) ([]domain.FileRef, error) {
+	return nil, nil
+}
+
+// ListEvents returns two events of request 1: file 2 succeeded, then the request completed.
+func (stubRepo) ListEvents(ctx context.Context, requestID int, afterID int64, limit int) ([]domain.Event, error) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	all := []domain.Event{
+		{ID: 1, RequestID: 1, Type: domain.EventFileSucceeded, FileID: 2, State: domain.FileSucceeded, BytesDownloaded: 20, BytesTotal: 20, CreatedAt: at},
+		{ID: 2, RequestID: 1, Type: domain.EventRequestCompleted, Status: domain.StatusDone, CreatedAt: at},
+	}
+	var events []domain.Event
+	for _, e := range all {
+		if e.RequestID == requestID && e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (stubRepo) ListProgress(ctx context.Context, requestID int) ([]domain.FileProgress, error) {
+	return nil, nil
+}
+
+// ListDeliveries returns a failed attempt and a successful retry for request 1.
+func (stubRepo) ListDeliveries(ctx context.Context, requestID int) ([]domain.WebhookDelivery, error) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []domain.WebhookDelivery{
+		{ID: 1, RequestID: 1, URL: "https://hooks.example.com/done", Attempt: 1, Status: domain.DeliveryFailed,
+			HTTPStatus: 503, Error: "callback answered 503 Service Unavailable", Duration: 120 * time.Millisecond, CreatedAt: at},
+		{ID: 2, RequestID: 1, URL: "https://hooks.example.com/done", Attempt: 2, Status: domain.DeliverySucceeded,
+			HTTPStatus: 200, Duration: 80 * time.Millisecond, CreatedAt: at.Add(10 * time.Second)},
+	}, nil
+}
+
+func (stubRepo) RetryFiles(ctx context.Context, requestID int, fileIDs []int) ([]domain.FileRef, error) {
+	return nil, nil
+}
+
+type stubDownloader struct{}
+
+func (stubDownloader) StartDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	return nil
+}
+
+func (stubDownloader) RetryDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	return nil
+}
+
+func (stubDownloader) AddFiles(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	return nil
+}
+
+func (stubDownloader) CancelDownload(ctx context.Context, requestID int, idempotencyKey string) error {
+	return nil
+}
+
+type readSeekNopCloser struct {
+	*strings.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+type stubBlobs struct{}
+
+func (stubBlobs) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+func (stubBlobs) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	return readSeekNopCloser{strings.NewReader(stubBlob(key))}, nil
+}
+
+func (stubBlobs) Stat(ctx context.Context, key string) (domain.BlobInfo, error) {
+	return domain.BlobInfo{Key: key, Size: int64(len(stubBlob(key))), ModTime: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}, nil
+}
+
+// stubBlob returns the stored bytes of key; "requests/1/gz" holds fileContent gzipped.
+func stubBlob(key string) string {
+	if key != "requests/1/gz" {
+		return fileContent
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(fileContent))
+	_ = zw.Close()
+	return buf.String()
+}
+
+func newHandler() http.Handler {
+	return httptransport.NewHandler(usecase.NewService(stubRepo{}, stubDownloader{}, stubBlobs{}, usecase.Config{}))
+}
+
+func doRequest(h http.Handler, method string, headers map[string]string) *httptest.ResponseRecorder {
+	return doFileRequest(h, method, "/downloads/1/files/2", headers)
+}
+
+func doFileRequest(h http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetFile_Full(t *testing.T) {
+	rec := doRequest(newHandler(), http.MethodGet, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec.Body.String() != fileContent {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+	if rec.Header().Get("ETag") == "" || rec.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected validators, got %v", rec.Header())
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("expected Accept-Ranges: bytes, got %q", rec.Header().Get("Accept-Ranges"))
+	}
+}
+
+func TestGetFile_Range(t *testing.T) {
+	rec := doRequest(newHandler(), http.MethodGet, map[string]string{"Range": "bytes=5-9"})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", rec.Code)
+	}
+	if rec.Body.String() != "56789" {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 5-9/20" {
+		t.Fatalf("unexpected Content-Range %q", got)
+	}
+}
+
+func TestGetFile_UnsatisfiableRange(t *testing.T) {
+	rec := doRequest(newHandler(), http.MethodGet, map[string]string{"Range": "bytes=100-"})
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected 416, got %d", rec.Code)
+	}
+}
+
+func TestGetFile_Conditional(t *testing.T) {
+	h := newHandler()
+	first := doRequest(h, http.MethodGet, nil)
+
+	rec := doRequest(h, http.MethodGet, map[string]string{"If-None-Match": first.Header().Get("ETag")})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", rec.Code)
+	}
+
+	rec = doRequest(h, http.MethodGet, map[string]string{"If-Modified-Since": first.Header().Get("Last-Modified")})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for If-Modified-Since, got %d", rec.Code)
+	}
+}
+
+func TestGetFile_Head(t *testing.T) {
+	rec := doRequest(newHandler(), http.MethodHead, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected empty body for HEAD, got %d bytes", rec.Body.Len())
+	}
+	if rec.Header().Get("Content-Length") != "20" {
+		t.Fatalf("expected Content-Length 20, got %q", rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestGetFile_Compressed(t *testing.T) {
+	h := newHandler()
+	path := "/downloads/1/files/3"
+
+	rec := doFileRequest(h, http.MethodGet, path, map[string]string{"Accept-Encoding": "br, gzip;q=0.8"})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected the stored gzip bytes, got %d %v", rec.Code, rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != fileContent {
+		t.Fatalf("unexpected decoded body %q", data)
+	}
+	encodedTag := rec.Header().Get("ETag")
+
+	rec = doFileRequest(h, http.MethodGet, path, map[string]string{"Accept-Encoding": "gzip;q=0", "Range": "bytes=5-9"})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("expected a decoded range, got %d %v", rec.Code, rec.Header())
+	}
+	if rec.Body.String() != "56789" || rec.Header().Get("Content-Range") != "bytes 5-9/20" {
+		t.Fatalf("unexpected range %q (%s)", rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	if rec.Header().Get("ETag") == encodedTag || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected distinct validators per representation, got %v", rec.Header())
+	}
+}
+
+func TestGetArchive_Zip(t *testing.T) {
+	rec := doFileRequest(newHandler(), http.MethodGet, "/downloads/1/archive", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected a zip archive, got %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename=request-1.zip` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		entries[f.Name] = string(data)
+	}
+	checkArchive(t, entries)
+}
+
+func TestGetArchive_TarGz(t *testing.T) {
+	rec := doFileRequest(newHandler(), http.MethodGet, "/downloads/1/archive?format=tar.gz", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("expected a tar.gz archive, got %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("open gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read %s: %v", hdr.Name, err)
+		}
+		entries[hdr.Name] = string(data)
+	}
+	checkArchive(t, entries)
+}
+
+// checkArchive verifies the archive of the stub request: two files that both want
+// the name report.csv, one of them stored gzipped, and one failed download.
+func checkArchive(t *testing.T, entries map[string]string) {
+	t.Helper()
+	if len(entries) != 3 || entries["report.csv"] != fileContent || entries["report (2).csv"] != fileContent {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	var manifest struct {
+		Files []struct {
+			ID    int    `json:"file_id"`
+			Name  string `json:"name"`
+			Error *struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(entries["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	if len(manifest.Files) != 3 || manifest.Files[1].Name != "report (2).csv" ||
+		manifest.Files[2].Error == nil || manifest.Files[2].Error.Code != "HTTP_404" {
+		t.Fatalf("unexpected manifest %s", entries["manifest.json"])
+	}
+}
+
+func TestGetArchive_Errors(t *testing.T) {
+	h := newHandler()
+	if rec := doFileRequest(h, http.MethodGet, "/downloads/1/archive?format=rar", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", rec.Code)
+	}
+	if rec := doFileRequest(h, http.MethodGet, "/downloads/9/archive", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown request, got %d", rec.Code)
+	}
+}
+
+func TestGetDeliveries(t *testing.T) {
+	h := newHandler()
+	rec := doFileRequest(h, http.MethodGet, "/downloads/1/deliveries", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	want := `{"deliveries":[` +
+		`{"id":1,"url":"https://hooks.example.com/done","attempt":1,"status":"FAILED","http_status":503,"duration_ms":120,` +
+		`"created_at":"2026-01-02T03:04:05Z","error":{"code":"DELIVERY_FAILED","message":"callback answered 503 Service Unavailable"}},` +
+		`{"id":2,"url":"https://hooks.example.com/done","attempt":2,"status":"SUCCEEDED","http_status":200,"duration_ms":80,` +
+		`"created_at":"2026-01-02T03:04:15Z"}]}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Fatalf("unexpected body %s", got)
+	}
+
+	if rec := doFileRequest(h, http.MethodGet, "/downloads/9/deliveries", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown request, got %d", rec.Code)
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	srv := httptest.NewServer(newHandler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/downloads/1/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get events: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// The stream stays open; read the first event, which follows Last-Event-ID.
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{
+		"id: 2",
+		"event: request.completed",
+		`data: {"request_id":1,"status":"DONE","time":"2026-01-02T03:04:05Z"}`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("unexpected event %q", lines)
+	}
+}
+
+func TestGetEvents_Errors(t *testing.T) {
+	h := newHandler()
+	if rec := doFileRequest(h, http.MethodGet, "/downloads/9/events", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown request, got %d", rec.Code)
+	}
+	rec := doFileRequest(h, http.MethodGet, "/downloads/1/events", map[string]string{"Last-Event-ID": "x"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid Last-Event-ID, got %d", rec.Code)
+	}
+}
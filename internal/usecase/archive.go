@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"async-file-storage/internal/archive"
+	"async-file-storage/internal/domain"
+)
+
+// codeContentMissing marks a downloaded file whose content could not be found
+// while the archive was written.
+const codeContentMissing = "CONTENT_MISSING"
+
+// Archive is a request whose files are ready to be streamed as one archive.
+type Archive struct {
+	// FileName is a suggested name for the archive, such as request-42.zip.
+	FileName    string
+	ContentType string
+
+	svc    *Service
+	format archive.Format
+	req    *domain.DownloadRequest
+	files  []domain.FileEntry
+}
+
+// OpenArchive checks that the files of a finished request can be archived in the
+// given format. Nothing is read until Stream is called.
+func (s *Service) OpenArchive(ctx context.Context, requestID int, format string) (*Archive, error) {
+	if requestID <= 0 {
+		return nil, ErrInvalidInput
+	}
+	f, err := archive.ParseFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	req, files, err := s.repo.GetRequestStatus(ctx, requestID)
+	if err != nil {
+		return nil, repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return nil, ErrGone
+	}
+	if req.Status == domain.StatusProcess {
+		return nil, fmt.Errorf("%w: request is still in progress", ErrConflict)
+	}
+
+	return &Archive{
+		FileName:    "request-" + strconv.Itoa(req.ID) + "." + string(f),
+		ContentType: f.ContentType(),
+		svc:         s,
+		format:      f,
+		req:         req,
+		files:       files,
+	}, nil
+}
+
+// Stream writes every downloaded file to w, one at a time, followed by a manifest
+// that lists all files of the request with their entry names or error codes.
+// Once Stream has written anything, an error leaves a truncated archive behind.
+func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
+	aw, err := archive.NewWriter(a.format, w)
+	if err != nil {
+		return err
+	}
+	names := archive.NewNames(archive.ManifestName)
+	manifest := archive.Manifest{
+		RequestID: a.req.ID,
+		Status:    string(a.req.Status),
+		CreatedAt: a.req.CreatedAt,
+		Files:     make([]archive.ManifestEntry, 0, len(a.files)),
+	}
+
+	for i := range a.files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f := &a.files[i]
+		entry := archive.ManifestEntry{ID: f.ID, URL: f.URL, State: string(f.State)}
+		switch {
+		case f.Error != "":
+			entry.Error = &archive.ManifestError{Code: f.Error}
+		case f.State == domain.FileSucceeded:
+			name := names.Unique(archive.EntryName(f.FileName, f.URL, f.ID))
+			err := a.writeFile(ctx, aw, name, f)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				entry.Error = &archive.ManifestError{Code: codeContentMissing}
+			case err != nil:
+				return fmt.Errorf("archive file %d: %w", f.ID, err)
+			default:
+				size := f.Size
+				entry.Name, entry.Size, entry.SHA256 = name, &size, f.ContentHash
+			}
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := aw.Create(archive.ManifestName, int64(len(data)), time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(data); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// writeFile copies the decoded content of one file into a new archive entry.
+// It returns ErrNotFound, before creating the entry, if the content is gone.
+func (a *Archive) writeFile(ctx context.Context, aw archive.Writer, name string, f *domain.FileEntry) error {
+	out, err := a.svc.openContent(ctx, f, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Content.Close() }()
+
+	modTime := out.ModTime
+	if f.FinishedAt != nil {
+		modTime = *f.FinishedAt
+	}
+	ew, err := aw.Create(name, out.Size, modTime)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(ew, out.Content)
+	if err != nil {
+		return err
+	}
+	if n != out.Size {
+		return fmt.Errorf("copied %d of %d bytes", n, out.Size)
+	}
+	return nil
+}
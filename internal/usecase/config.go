@@ -0,0 +1,23 @@
+package usecase
+
+import "time"
+
+// Config holds the server-wide policies applied by Service.
+type Config struct {
+	// DefaultRetention is how long a request is kept when the client does not set
+	// an expiry. Zero keeps such requests forever.
+	DefaultRetention time.Duration
+	// MaxRetention is the latest expiry a client may ask for, relative to creation.
+	// Zero means no limit.
+	MaxRetention time.Duration
+	// RejectDuplicateURLs makes a request that lists the same URL twice invalid.
+	// Otherwise every occurrence is downloaded as a separate file.
+	RejectDuplicateURLs bool
+	// Keys unwraps the data keys of encrypted files. Nil serves only unencrypted files.
+	Keys KeyUnwrapper
+	// AllowCallbacks accepts requests with a callback URL. The worker needs a webhook
+	// secret to sign what it sends there.
+	AllowCallbacks bool
+	// Events wakes event streams when events are recorded. Nil makes every stream poll.
+	Events EventNotifier
+}
@@ -0,0 +1,46 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"async-file-storage/internal/domain"
+)
+
+const (
+	SortCreatedAsc  = "created_at"
+	SortCreatedDesc = "-created_at"
+
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// cursor is the opaque page token handed to clients. It carries the sort it was
+// made for, so a token cannot silently continue a listing in the other order.
+type cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int       `json:"id"`
+	Sort      string    `json:"s"`
+}
+
+func encodeCursor(pos domain.RequestCursor, sort string) string {
+	data, _ := json.Marshal(cursor{CreatedAt: pos.CreatedAt, ID: pos.ID, Sort: sort})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string, sort string) (domain.RequestCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return domain.RequestCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID <= 0 {
+		return domain.RequestCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	if c.Sort != sort {
+		return domain.RequestCursor{}, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidInput, c.Sort)
+	}
+	return domain.RequestCursor{CreatedAt: c.CreatedAt, ID: c.ID}, nil
+}
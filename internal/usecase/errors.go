@@ -5,6 +5,8 @@ import "errors"
 var (
 	ErrInvalidInput = errors.New("invalid input")
 	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrGone         = errors.New("gone")
 )
 
 type BusinessError struct {
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"async-file-storage/internal/domain"
+)
+
+const (
+	// eventBatchSize bounds the events read from the repository at once.
+	eventBatchSize = 100
+	// eventKeepAlive is how long a stream stays silent before it asks for a keep-alive.
+	// The request is checked for deletion at the same pace.
+	eventKeepAlive = 15 * time.Second
+	// eventPollInterval is how often a stream without an EventNotifier looks for events.
+	eventPollInterval = time.Second
+)
+
+// EventStream follows the events of one request.
+type EventStream struct {
+	svc       *Service
+	requestID int
+	lastID    int64
+}
+
+// OpenEventStream checks that the request exists. The stream starts behind the event
+// lastEventID, or at the first event when it is zero.
+func (s *Service) OpenEventStream(ctx context.Context, requestID int, lastEventID int64) (*EventStream, error) {
+	if requestID <= 0 || lastEventID < 0 {
+		return nil, ErrInvalidInput
+	}
+	req, err := s.repo.GetRequest(ctx, requestID)
+	if err != nil {
+		return nil, repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return nil, ErrGone
+	}
+	return &EventStream{svc: s, requestID: requestID, lastID: lastEventID}, nil
+}
+
+// Stream passes the events of the request to send in id order until ctx is done or
+// the request is deleted, which yields ErrNotFound or ErrGone. When nothing was sent
+// for a while send gets no events, so the caller can keep its connection alive.
+func (e *EventStream) Stream(ctx context.Context, send func([]domain.Event) error) error {
+	var wake <-chan struct{}
+	interval := eventPollInterval
+	if e.svc.cfg.Events != nil {
+		// Subscribing before the first read means no event recorded after it goes unnoticed.
+		ch, unsubscribe := e.svc.cfg.Events.Subscribe(e.requestID)
+		defer unsubscribe()
+		wake, interval = ch, eventKeepAlive
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastSent := time.Now()
+	for {
+		events, err := e.svc.repo.ListEvents(ctx, e.requestID, e.lastID, eventBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("list events: %w", err)
+		}
+		if len(events) > 0 {
+			if err := send(events); err != nil {
+				return err
+			}
+			e.lastID = events[len(events)-1].ID
+			lastSent = time.Now()
+			if len(events) == eventBatchSize {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-ticker.C:
+			if time.Since(lastSent) < eventKeepAlive {
+				continue
+			}
+			if _, err := e.svc.repo.GetRequest(ctx, e.requestID); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return repoError("get request", err)
+			}
+			if err := send(nil); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+	}
+}
@@ -2,17 +2,54 @@ package usecase
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"async-file-storage/internal/domain"
 )
 
 type Repository interface {
-	CreateRequest(ctx context.Context, urls []string) (int, error)
+	CreateRequest(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error)
+	GetRequest(ctx context.Context, id int) (*domain.DownloadRequest, error)
 	GetRequestStatus(ctx context.Context, id int) (*domain.DownloadRequest, []domain.FileEntry, error)
+	ListRequests(ctx context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error)
 	GetFile(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error)
+	DeleteRequest(ctx context.Context, id int) ([]string, error)
+	RetryFiles(ctx context.Context, requestID int, fileIDs []int) ([]domain.FileRef, error)
+	AppendFiles(ctx context.Context, requestID int, files domain.NewRequest, reopen bool) ([]domain.FileRef, error)
+	ListEvents(ctx context.Context, requestID int, afterID int64, limit int) ([]domain.Event, error)
+	ListDeliveries(ctx context.Context, requestID int) ([]domain.WebhookDelivery, error)
+	// ListProgress returns the progress last recorded for the files of a request that are downloading.
+	ListProgress(ctx context.Context, requestID int) ([]domain.FileProgress, error)
 }
 
 type Downloader interface {
-	StartDownload(ctx context.Context, requestID int, urls []string, timeout time.Duration) error
+	// StartDownload starts downloading the files of a request. Starting the same request, or
+	// the same idempotency key, again while or after it ran does nothing.
+	StartDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error
+	// RetryDownload starts another download run of a finished request for the given files.
+	RetryDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error
+	// AddFiles hands files added to a request to its running download, or starts a new
+	// run for them when the download has finished.
+	AddFiles(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error
+	// CancelDownload stops the download of a request. It returns domain.ErrNotFound
+	// when the download is not running.
+	CancelDownload(ctx context.Context, requestID int, idempotencyKey string) error
+}
+
+type BlobStore interface {
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, key string) (domain.BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// EventNotifier wakes the event stream of a request when new events may have been
+// recorded. Subscribe returns the wake-up channel and the function that ends the subscription.
+type EventNotifier interface {
+	Subscribe(requestID int) (<-chan struct{}, func())
+}
+
+// KeyUnwrapper recovers the plaintext data key of an encrypted file.
+type KeyUnwrapper interface {
+	Unwrap(dk domain.DataKey) ([]byte, error)
 }
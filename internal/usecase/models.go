@@ -1,33 +1,135 @@
 package usecase
 
 import (
+	"io"
 	"time"
 
 	"async-file-storage/internal/domain"
 )
 
 type CreateRequestInput struct {
-	URLs    []string
-	Timeout time.Duration
+	URLs []string
+	// Checksums are the digests the files must match, Checksums[i] for URLs[i]; may be nil.
+	Checksums []domain.Digests
+	Timeout   time.Duration
+	// ExpiresAt overrides the default retention; nil uses Config.DefaultRetention.
+	ExpiresAt *time.Time
+	// IdempotencyKey makes retries of the same creation return the request created first.
+	IdempotencyKey string
+	// CallbackURL is sent the status of the request when it finishes; empty sends nothing.
+	CallbackURL string
 }
 
 type CreateRequestOutput struct {
+	ID        int
+	Status    domain.Status
+	ExpiresAt *time.Time
+}
+
+// AddFilesInput appends URLs to an existing request.
+type AddFilesInput struct {
+	ID   int
+	URLs []string
+	// Checksums are the digests the files must match, Checksums[i] for URLs[i]; may be nil.
+	Checksums []domain.Digests
+	// Reopen allows adding files to a finished request, which then downloads them.
+	Reopen bool
+}
+
+type AddFilesOutput struct {
 	ID     int
 	Status domain.Status
+	// Files are the added files, in the order of the URLs.
+	Files []domain.FileRef
+}
+
+// RetryRequestInput selects the failed files of a finished request to download again.
+type RetryRequestInput struct {
+	ID int
+	// FileIDs lists the files to retry; empty retries every failed file.
+	FileIDs []int
+	// Timeout bounds the new run; zero reuses the timeout the request was created with.
+	Timeout time.Duration
+}
+
+type RetryRequestOutput struct {
+	ID      int
+	Status  domain.Status
+	FileIDs []int
 }
 
 type GetRequestOutput struct {
-	ID     int
-	Status domain.Status
-	Files  []FileStatus
+	ID          int
+	Status      domain.Status
+	ExpiresAt   *time.Time
+	CallbackURL string
+	Files       []FileStatus
+	// Progress sums up the files that downloaded or are downloading.
+	Progress Progress
+}
+
+// Progress is how far a download has got. BytesTotal is zero while it is not known,
+// and ETA while the remaining time cannot be estimated.
+type Progress struct {
+	BytesDownloaded int64
+	BytesTotal      int64
+	ETA             time.Duration
+}
+
+// ListDeliveriesOutput lists the attempts to deliver the webhook of a request, oldest first.
+type ListDeliveriesOutput struct {
+	CallbackURL string
+	Deliveries  []domain.WebhookDelivery
+}
+
+// ListRequestsInput filters and pages the request listing. Zero fields do not filter.
+type ListRequestsInput struct {
+	Status      domain.Status
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	URLContains string
+	Host        string
+	// Sort is SortCreatedAsc or SortCreatedDesc; empty means newest first.
+	Sort string
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	// Limit is the page size; zero picks the default.
+	Limit int
+}
+
+type ListRequestsOutput struct {
+	Requests []RequestSummary
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+type RequestSummary struct {
+	ID        int
+	Status    domain.Status
+	CreatedAt time.Time
+	ExpiresAt *time.Time
 }
 
 type FileStatus struct {
 	URL       string
 	FileID    int
+	State     domain.FileState
+	Size      int64
 	ErrorCode string
+	Attempts  int
+	// Progress is set for files that downloaded or are downloading.
+	Progress *Progress
+	domain.FileMeta
 }
 
+// GetFileOutput carries the stored file content and its validators; the caller must close Content.
 type GetFileOutput struct {
-	Data []byte
+	Content io.ReadSeekCloser
+	// Size is the length of Content, which is encoded with Encoding.
+	Size     int64
+	Encoding domain.ContentEncoding
+	ModTime  time.Time
+	// ETag is a strong, quoted entity tag. Stored content never changes under
+	// the same storage key, so the tag is derived from the key.
+	ETag string
 }
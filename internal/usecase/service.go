@@ -2,42 +2,197 @@ package usecase
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
 
+	"async-file-storage/internal/codec"
 	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
 )
 
+// maxIdempotencyKeyLength bounds the Idempotency-Key a client may send.
+const maxIdempotencyKeyLength = 255
+
 type Service struct {
 	repo       Repository
 	downloader Downloader
+	blobs      BlobStore
+	cfg        Config
 }
 
-func NewService(repo Repository, downloader Downloader) *Service {
-	return &Service{repo: repo, downloader: downloader}
+func NewService(repo Repository, downloader Downloader, blobs BlobStore, cfg Config) *Service {
+	return &Service{repo: repo, downloader: downloader, blobs: blobs, cfg: cfg}
 }
 
 func (s *Service) CreateRequest(ctx context.Context, input CreateRequestInput) (CreateRequestOutput, error) {
 	if len(input.URLs) == 0 || input.Timeout <= 0 {
 		return CreateRequestOutput{}, ErrInvalidInput
 	}
-	for _, url := range input.URLs {
-		if strings.TrimSpace(url) == "" {
-			return CreateRequestOutput{}, ErrInvalidInput
-		}
+	checksums, err := s.checkFiles(input.URLs, input.Checksums, nil)
+	if err != nil {
+		return CreateRequestOutput{}, err
+	}
+	expiresAt, err := s.expiresAt(input.ExpiresAt, time.Now())
+	if err != nil {
+		return CreateRequestOutput{}, err
+	}
+
+	if len(input.IdempotencyKey) > maxIdempotencyKeyLength {
+		return CreateRequestOutput{}, fmt.Errorf("%w: idempotency key is longer than %d bytes", ErrInvalidInput, maxIdempotencyKeyLength)
+	}
+	if err := s.checkCallbackURL(input.CallbackURL); err != nil {
+		return CreateRequestOutput{}, err
 	}
 
-	requestID, err := s.repo.CreateRequest(ctx, input.URLs)
+	newReq := domain.NewRequest{
+		URLs: input.URLs, Checksums: checksums, Timeout: input.Timeout, ExpiresAt: expiresAt, CallbackURL: input.CallbackURL,
+	}
+	if input.IdempotencyKey != "" {
+		newReq.IdempotencyKey = input.IdempotencyKey
+		newReq.Fingerprint = fingerprint(input, checksums)
+	}
+	requestID, files, err := s.repo.CreateRequest(ctx, newReq)
+	if errors.Is(err, domain.ErrIdempotencyMismatch) {
+		return CreateRequestOutput{}, fmt.Errorf("%w: %v", ErrConflict, err)
+	}
 	if err != nil {
 		return CreateRequestOutput{}, fmt.Errorf("create request: %w", err)
 	}
+	if input.IdempotencyKey != "" {
+		// A retried creation gets the expiry of the request created first.
+		req, err := s.repo.GetRequest(ctx, requestID)
+		if err != nil {
+			return CreateRequestOutput{}, repoError("get request", err)
+		}
+		expiresAt = req.ExpiresAt
+	}
 
-	if err := s.downloader.StartDownload(ctx, requestID, input.URLs, input.Timeout); err != nil {
+	// On a retry this also starts the workflow in case the first attempt died before it could.
+	if err := s.downloader.StartDownload(ctx, requestID, files, input.Timeout, input.IdempotencyKey); err != nil {
 		return CreateRequestOutput{}, fmt.Errorf("start download: %w", err)
 	}
 
-	return CreateRequestOutput{ID: requestID, Status: domain.StatusProcess}, nil
+	return CreateRequestOutput{ID: requestID, Status: domain.StatusProcess, ExpiresAt: expiresAt}, nil
+}
+
+// checkFiles validates the URLs and expected checksums of new files and returns the
+// normalized checksums. existing lists the URLs the request already has.
+func (s *Service) checkFiles(urls []string, checksums []domain.Digests, existing []string) ([]domain.Digests, error) {
+	seen := make(map[string]bool, len(existing)+len(urls))
+	for _, url := range existing {
+		seen[url] = true
+	}
+	for _, url := range urls {
+		if strings.TrimSpace(url) == "" {
+			return nil, ErrInvalidInput
+		}
+		if seen[url] && s.cfg.RejectDuplicateURLs {
+			return nil, fmt.Errorf("%w: duplicate url %q", ErrInvalidInput, url)
+		}
+		seen[url] = true
+	}
+	if len(checksums) > len(urls) {
+		return nil, fmt.Errorf("%w: more checksums than urls", ErrInvalidInput)
+	}
+	normalized := make([]domain.Digests, len(checksums))
+	for i, c := range checksums {
+		var err error
+		if normalized[i], err = normalizeChecksums(c); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidInput, urls[i], err)
+		}
+	}
+	return normalized, nil
+}
+
+// maxCallbackURLLength bounds the callback URL a client may send.
+const maxCallbackURLLength = 2048
+
+// checkCallbackURL accepts an empty URL or an absolute http(s) one.
+func (s *Service) checkCallbackURL(callback string) error {
+	if callback == "" {
+		return nil
+	}
+	if !s.cfg.AllowCallbacks {
+		return fmt.Errorf("%w: callbacks are not enabled", ErrInvalidInput)
+	}
+	u, err := url.Parse(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(callback) > maxCallbackURLLength {
+		return fmt.Errorf("%w: callback_url must be an absolute http or https URL", ErrInvalidInput)
+	}
+	return nil
+}
+
+// fingerprint identifies the body of a creation request, so that an idempotency key
+// reused for a different request can be told from a retry.
+func fingerprint(input CreateRequestInput, checksums []domain.Digests) string {
+	h := sha256.New()
+	for i, url := range input.URLs {
+		var c domain.Digests
+		if i < len(checksums) {
+			c = checksums[i]
+		}
+		fmt.Fprintf(h, "%q %q %q %q\n", url, c.SHA256, c.SHA512, c.MD5)
+	}
+	fmt.Fprintf(h, "timeout %d\n", input.Timeout)
+	if input.ExpiresAt != nil {
+		fmt.Fprintf(h, "expires_at %d\n", input.ExpiresAt.UnixNano())
+	}
+	if input.CallbackURL != "" {
+		fmt.Fprintf(h, "callback_url %q\n", input.CallbackURL)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeChecksums checks that every expected digest is hex of the right length
+// and lowercases it.
+func normalizeChecksums(c domain.Digests) (domain.Digests, error) {
+	for _, d := range []struct {
+		name   string
+		value  *string
+		length int
+	}{
+		{"sha256", &c.SHA256, sha256.Size},
+		{"sha512", &c.SHA512, sha512.Size},
+		{"md5", &c.MD5, md5.Size},
+	} {
+		if *d.value == "" {
+			continue
+		}
+		if raw, err := hex.DecodeString(*d.value); err != nil || len(raw) != d.length {
+			return domain.Digests{}, fmt.Errorf("%s must be %d hex digits", d.name, 2*d.length)
+		}
+		*d.value = strings.ToLower(*d.value)
+	}
+	return c, nil
+}
+
+// expiresAt applies the server retention policy to the requested expiry.
+func (s *Service) expiresAt(requested *time.Time, now time.Time) (*time.Time, error) {
+	if requested == nil {
+		if s.cfg.DefaultRetention <= 0 {
+			return nil, nil
+		}
+		at := now.Add(s.cfg.DefaultRetention)
+		return &at, nil
+	}
+	if !requested.After(now) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidInput)
+	}
+	if s.cfg.MaxRetention > 0 && requested.After(now.Add(s.cfg.MaxRetention)) {
+		return nil, fmt.Errorf("%w: expires_at exceeds the maximum retention of %s", ErrInvalidInput, s.cfg.MaxRetention)
+	}
+	return requested, nil
 }
 
 func (s *Service) GetRequest(ctx context.Context, id int) (GetRequestOutput, error) {
@@ -47,48 +202,508 @@ func (s *Service) GetRequest(ctx context.Context, id int) (GetRequestOutput, err
 
 	req, files, err := s.repo.GetRequestStatus(ctx, id)
 	if err != nil {
-		// TODO: обрабатываешь одну и ту же ошибку только из разных пакетов,
-		// может стоит унифицировать ошибку NotFound в одном пакете и использовать её везде?
-		if errors.Is(err, ErrNotFound) || errors.Is(err, domain.ErrNotFound) {
-			return GetRequestOutput{}, ErrNotFound
-		}
-		return GetRequestOutput{}, fmt.Errorf("get request: %w", err)
+		return GetRequestOutput{}, repoError("get request", err)
 	}
+	if req.Expired(time.Now()) {
+		return GetRequestOutput{}, ErrGone
+	}
+
+	var running map[int]domain.FileProgress
+	if req.Status == domain.StatusProcess {
+		running = s.progress(ctx, req, files)
+	}
+	return requestStatus(req, files, running, time.Now()), nil
+}
+
+// RequestStatus is what GetRequest returns for a request that has finished, and so
+// has no downloads running.
+func RequestStatus(req *domain.DownloadRequest, files []domain.FileEntry) GetRequestOutput {
+	return requestStatus(req, files, nil, time.Now())
+}
 
-	out := GetRequestOutput{ID: req.ID, Status: req.Status}
+// requestStatus describes a request and its files, with the progress of the running downloads.
+func requestStatus(req *domain.DownloadRequest, files []domain.FileEntry, running map[int]domain.FileProgress, now time.Time) GetRequestOutput {
+	out := GetRequestOutput{ID: req.ID, Status: req.Status, ExpiresAt: req.ExpiresAt, CallbackURL: req.CallbackURL}
 	out.Files = make([]FileStatus, 0, len(files))
 	for _, f := range files {
-		status := FileStatus{URL: f.URL}
+		status := FileStatus{URL: f.URL, FileID: f.ID, State: f.State, Attempts: f.Attempts, FileMeta: f.FileMeta}
+		switch p, ok := running[f.ID]; {
+		case f.State == domain.FileSucceeded:
+			status.Progress = &Progress{BytesDownloaded: f.Size, BytesTotal: f.Size}
+		case f.State == domain.FileDownloading && ok:
+			status.Progress = &Progress{BytesDownloaded: p.BytesDownloaded, BytesTotal: p.BytesTotal}
+			status.Progress.ETA = eta(p.BytesTotal-p.BytesDownloaded, rate(p, now))
+		}
+		if status.Digests.SHA256 == "" {
+			status.Digests.SHA256 = f.ContentHash
+		}
 		// лучше возвращать указатель чтобы сравнивать через f.Error != nil, а не через пустую строку,
 		// так как может быть ситуация когда ошибка есть, но она не описана, и тогда будет возвращаться пустая строка,
 		// что может ввести в заблуждение
 		if f.Error != "" {
 			status.ErrorCode = f.Error
 		} else {
-			status.FileID = f.ID
+			status.Size = f.Size
 		}
 		out.Files = append(out.Files, status)
 	}
+	out.Progress = requestProgress(files, running, now)
+	return out
+}
+
+// progress returns the progress the worker last recorded for the downloading files of a
+// request, by file id, or nil if it cannot be read.
+func (s *Service) progress(ctx context.Context, req *domain.DownloadRequest, files []domain.FileEntry) map[int]domain.FileProgress {
+	reports, err := s.repo.ListProgress(ctx, req.ID)
+	if err != nil {
+		log.Printf("progress of request %d: %v", req.ID, err)
+		return nil
+	}
+	started := make(map[int]time.Time, len(files))
+	for _, f := range files {
+		if f.State == domain.FileDownloading && f.StartedAt != nil {
+			started[f.ID] = *f.StartedAt
+		}
+	}
+	byID := make(map[int]domain.FileProgress, len(reports))
+	for _, p := range reports {
+		if at, ok := started[p.FileID]; ok {
+			p.StartedAt = at
+			byID[p.FileID] = p
+		}
+	}
+	return byID
+}
+
+// requestProgress sums up the progress of a request. The total is known once every file
+// that is still to download has announced its size; files that failed do not count.
+func requestProgress(files []domain.FileEntry, running map[int]domain.FileProgress, now time.Time) Progress {
+	var out Progress
+	var bytesPerSecond float64
+	totalKnown := true
+	for _, f := range files {
+		p, ok := running[f.ID]
+		switch {
+		case f.State == domain.FileSucceeded:
+			out.BytesDownloaded += f.Size
+			out.BytesTotal += f.Size
+		case f.State == domain.FileDownloading && ok:
+			out.BytesDownloaded += p.BytesDownloaded
+			out.BytesTotal += p.BytesTotal
+			totalKnown = totalKnown && p.BytesTotal > 0
+			bytesPerSecond += rate(p, now)
+		case !f.State.Finished():
+			totalKnown = false
+		}
+	}
+	if !totalKnown {
+		out.BytesTotal = 0
+		return out
+	}
+	out.ETA = eta(out.BytesTotal-out.BytesDownloaded, bytesPerSecond)
+	return out
+}
+
+// rate is the average speed of a download so far, in bytes per second.
+func rate(p domain.FileProgress, now time.Time) float64 {
+	elapsed := now.Sub(p.StartedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.BytesDownloaded) / elapsed
+}
+
+// eta estimates how long the remaining bytes take, rounded up to a second. It is zero
+// when it cannot be estimated.
+func eta(remaining int64, bytesPerSecond float64) time.Duration {
+	if remaining <= 0 || bytesPerSecond <= 0 {
+		return 0
+	}
+	seconds := math.Ceil(float64(remaining) / bytesPerSecond)
+	return time.Duration(seconds) * time.Second
+}
+
+// ListRequests returns one page of requests that have not expired. Pages are cut on
+// (created_at, id), so requests created while paging never shift later pages.
+func (s *Service) ListRequests(ctx context.Context, input ListRequestsInput) (ListRequestsOutput, error) {
+	limit := input.Limit
+	switch {
+	case limit == 0:
+		limit = defaultListLimit
+	case limit < 0 || limit > maxListLimit:
+		return ListRequestsOutput{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, maxListLimit)
+	}
+
+	sort := input.Sort
+	if sort == "" {
+		sort = SortCreatedDesc
+	}
+	if sort != SortCreatedAsc && sort != SortCreatedDesc {
+		return ListRequestsOutput{}, fmt.Errorf("%w: unknown sort %q", ErrInvalidInput, input.Sort)
+	}
+
+	switch input.Status {
+	case "", domain.StatusProcess, domain.StatusDone, domain.StatusPartial, domain.StatusError, domain.StatusCanceled:
+	default:
+		return ListRequestsOutput{}, fmt.Errorf("%w: unknown status %q", ErrInvalidInput, input.Status)
+	}
+
+	now := time.Now()
+	query := domain.ListRequestsQuery{
+		Filter: domain.RequestFilter{
+			Status:      input.Status,
+			CreatedFrom: input.CreatedFrom,
+			CreatedTo:   input.CreatedTo,
+			URLContains: input.URLContains,
+			Host:        input.Host,
+			ActiveAt:    &now,
+		},
+		Descending: sort == SortCreatedDesc,
+		// One extra row tells whether there is a next page.
+		Limit: limit + 1,
+	}
+	if input.Cursor != "" {
+		after, err := decodeCursor(input.Cursor, sort)
+		if err != nil {
+			return ListRequestsOutput{}, err
+		}
+		query.After = &after
+	}
+
+	requests, err := s.repo.ListRequests(ctx, query)
+	if err != nil {
+		return ListRequestsOutput{}, fmt.Errorf("list requests: %w", err)
+	}
+
+	var out ListRequestsOutput
+	if len(requests) > limit {
+		requests = requests[:limit]
+		last := requests[limit-1]
+		out.NextCursor = encodeCursor(domain.RequestCursor{CreatedAt: last.CreatedAt, ID: last.ID}, sort)
+	}
+	out.Requests = make([]RequestSummary, 0, len(requests))
+	for _, req := range requests {
+		out.Requests = append(out.Requests, RequestSummary{
+			ID:        req.ID,
+			Status:    req.Status,
+			CreatedAt: req.CreatedAt,
+			ExpiresAt: req.ExpiresAt,
+		})
+	}
 	return out, nil
 }
 
-func (s *Service) GetFile(ctx context.Context, requestID int, fileID int) (GetFileOutput, error) {
+// CancelRequest stops a request that is still downloading. Cancellation is
+// asynchronous: transfers in progress are aborted, files not finished yet become
+// CANCELED and the request ends up CANCELED shortly after.
+func (s *Service) CancelRequest(ctx context.Context, id int) error {
+	if id <= 0 {
+		return ErrInvalidInput
+	}
+
+	req, err := s.repo.GetRequest(ctx, id)
+	if err != nil {
+		return repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return ErrGone
+	}
+	if req.Status != domain.StatusProcess {
+		return fmt.Errorf("%w: request has already finished", ErrConflict)
+	}
+
+	err = s.downloader.CancelDownload(ctx, req.ID, req.IdempotencyKey)
+	if errors.Is(err, domain.ErrNotFound) {
+		// The workflow finished between reading the status and canceling it.
+		return fmt.Errorf("%w: request has already finished", ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("cancel download: %w", err)
+	}
+	return nil
+}
+
+// AddFiles appends URLs to a request. The running download picks them up; a finished
+// request is rejected with ErrConflict unless input.Reopen is set, in which case a new
+// download run is started for the added files.
+func (s *Service) AddFiles(ctx context.Context, input AddFilesInput) (AddFilesOutput, error) {
+	if input.ID <= 0 || len(input.URLs) == 0 {
+		return AddFilesOutput{}, ErrInvalidInput
+	}
+
+	req, files, err := s.repo.GetRequestStatus(ctx, input.ID)
+	if err != nil {
+		return AddFilesOutput{}, repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return AddFilesOutput{}, ErrGone
+	}
+	if req.Status != domain.StatusProcess && !input.Reopen {
+		return AddFilesOutput{}, fmt.Errorf("%w: request has already finished", ErrConflict)
+	}
+	if req.Timeout == 0 {
+		return AddFilesOutput{}, fmt.Errorf("%w: request has no recorded timeout", ErrConflict)
+	}
+	existing := make([]string, 0, len(files))
+	for _, f := range files {
+		existing = append(existing, f.URL)
+	}
+	checksums, err := s.checkFiles(input.URLs, input.Checksums, existing)
+	if err != nil {
+		return AddFilesOutput{}, err
+	}
+
+	refs, err := s.repo.AppendFiles(ctx, req.ID, domain.NewRequest{URLs: input.URLs, Checksums: checksums}, input.Reopen)
+	if errors.Is(err, domain.ErrFinished) {
+		return AddFilesOutput{}, fmt.Errorf("%w: request has already finished", ErrConflict)
+	}
+	if err != nil {
+		return AddFilesOutput{}, repoError("append files", err)
+	}
+
+	if err := s.downloader.AddFiles(ctx, req.ID, refs, req.Timeout, req.IdempotencyKey); err != nil {
+		return AddFilesOutput{}, fmt.Errorf("add files to download: %w", err)
+	}
+	return AddFilesOutput{ID: req.ID, Status: domain.StatusProcess, Files: refs}, nil
+}
+
+// RetryRequest downloads failed files of a finished request again in a new run.
+// The files keep their ids; the request is PROCESS until the run finishes.
+func (s *Service) RetryRequest(ctx context.Context, input RetryRequestInput) (RetryRequestOutput, error) {
+	if input.ID <= 0 || input.Timeout < 0 {
+		return RetryRequestOutput{}, ErrInvalidInput
+	}
+
+	req, files, err := s.repo.GetRequestStatus(ctx, input.ID)
+	if err != nil {
+		return RetryRequestOutput{}, repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return RetryRequestOutput{}, ErrGone
+	}
+	if req.Status == domain.StatusProcess {
+		return RetryRequestOutput{}, fmt.Errorf("%w: request is still in progress", ErrConflict)
+	}
+	timeout := input.Timeout
+	if timeout == 0 {
+		timeout = req.Timeout
+	}
+	if timeout == 0 {
+		return RetryRequestOutput{}, fmt.Errorf("%w: timeout is required for this request", ErrInvalidInput)
+	}
+
+	fileIDs, err := retryableFiles(files, input.FileIDs)
+	if err != nil {
+		return RetryRequestOutput{}, err
+	}
+
+	refs, err := s.repo.RetryFiles(ctx, req.ID, fileIDs)
+	if errors.Is(err, domain.ErrInProgress) {
+		return RetryRequestOutput{}, fmt.Errorf("%w: request is still in progress", ErrConflict)
+	}
+	if err != nil {
+		return RetryRequestOutput{}, repoError("retry files", err)
+	}
+
+	if err := s.downloader.RetryDownload(ctx, req.ID, refs, timeout, req.IdempotencyKey); err != nil {
+		return RetryRequestOutput{}, fmt.Errorf("start download: %w", err)
+	}
+	return RetryRequestOutput{ID: req.ID, Status: domain.StatusProcess, FileIDs: fileIDs}, nil
+}
+
+// retryableFiles picks the files to retry: the requested ones, each of which must
+// have failed, or else every failed file.
+func retryableFiles(files []domain.FileEntry, requested []int) ([]int, error) {
+	if len(requested) == 0 {
+		var ids []int
+		for _, f := range files {
+			if f.State.Retryable() {
+				ids = append(ids, f.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("%w: request has no failed files", ErrConflict)
+		}
+		return ids, nil
+	}
+
+	states := make(map[int]domain.FileState, len(files))
+	for _, f := range files {
+		states[f.ID] = f.State
+	}
+	ids := make([]int, 0, len(requested))
+	for _, id := range requested {
+		state, ok := states[id]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: file %d", ErrNotFound, id)
+		case !state.Retryable():
+			return nil, fmt.Errorf("%w: file %d is %s", ErrConflict, id, state)
+		case !slices.Contains(ids, id):
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// DeleteRequest removes a finished request and its files. Stored content shared
+// with other requests is kept; blobs nothing references any more are deleted.
+func (s *Service) DeleteRequest(ctx context.Context, id int) error {
+	if id <= 0 {
+		return ErrInvalidInput
+	}
+
+	req, err := s.repo.GetRequest(ctx, id)
+	if err != nil {
+		return repoError("get request", err)
+	}
+	if req.Status == domain.StatusProcess {
+		return fmt.Errorf("%w: request is still in progress", ErrConflict)
+	}
+
+	orphaned, err := s.repo.DeleteRequest(ctx, id)
+	if err != nil {
+		return repoError("delete request", err)
+	}
+
+	// The request is gone at this point; a blob that fails to delete is only wasted space.
+	for _, key := range orphaned {
+		if err := s.blobs.Delete(ctx, key); err != nil && !errors.Is(err, domain.ErrNotFound) {
+			log.Printf("delete blob %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// ListDeliveries returns the attempts to deliver the webhook of a request.
+func (s *Service) ListDeliveries(ctx context.Context, id int) (ListDeliveriesOutput, error) {
+	if id <= 0 {
+		return ListDeliveriesOutput{}, ErrInvalidInput
+	}
+	req, err := s.repo.GetRequest(ctx, id)
+	if err != nil {
+		return ListDeliveriesOutput{}, repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return ListDeliveriesOutput{}, ErrGone
+	}
+	deliveries, err := s.repo.ListDeliveries(ctx, id)
+	if err != nil {
+		return ListDeliveriesOutput{}, fmt.Errorf("list deliveries: %w", err)
+	}
+	return ListDeliveriesOutput{CallbackURL: req.CallbackURL, Deliveries: deliveries}, nil
+}
+
+// GetFile opens the content of a downloaded file. Encrypted content is always decrypted.
+// Content stored compressed is returned as it is stored when its encoding is among accept,
+// and decoded on the fly otherwise.
+func (s *Service) GetFile(ctx context.Context, requestID int, fileID int, accept []domain.ContentEncoding) (GetFileOutput, error) {
 	if requestID <= 0 || fileID <= 0 {
 		return GetFileOutput{}, ErrInvalidInput
 	}
 
+	req, err := s.repo.GetRequest(ctx, requestID)
+	if err != nil {
+		return GetFileOutput{}, repoError("get request", err)
+	}
+	if req.Expired(time.Now()) {
+		return GetFileOutput{}, ErrGone
+	}
+
 	file, err := s.repo.GetFile(ctx, requestID, fileID)
 	if err != nil {
-		// TODO: та же ситуация с ошибкой NotFound, надо унифицировать её в одном пакете и использовать везде,
-		// чтобы не проверять её из разных пакетов
-		if errors.Is(err, ErrNotFound) || errors.Is(err, domain.ErrNotFound) {
-			return GetFileOutput{}, ErrNotFound
-		}
-		return GetFileOutput{}, fmt.Errorf("get file: %w", err)
+		return GetFileOutput{}, repoError("get file", err)
 	}
 	if file.Error != "" {
 		return GetFileOutput{}, BusinessError{Code: file.Error, Msg: "file not available"}
 	}
 
-	return GetFileOutput{Data: file.Data}, nil
+	return s.openContent(ctx, file, accept)
+}
+
+// openContent opens the stored content of a downloaded file, decrypting it and, unless
+// its encoding is among accept, decoding it.
+func (s *Service) openContent(ctx context.Context, file *domain.FileEntry, accept []domain.ContentEncoding) (GetFileOutput, error) {
+	info, err := s.blobs.Stat(ctx, file.StorageKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return GetFileOutput{}, ErrNotFound
+		}
+		return GetFileOutput{}, fmt.Errorf("stat file content: %w", err)
+	}
+	content, err := s.blobs.Get(ctx, file.StorageKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return GetFileOutput{}, ErrNotFound
+		}
+		return GetFileOutput{}, fmt.Errorf("open file content: %w", err)
+	}
+	size := info.Size
+	if file.DataKey.Encrypted() {
+		if content, size, err = s.decrypt(file.DataKey, content, info.Size); err != nil {
+			return GetFileOutput{}, err
+		}
+	}
+
+	out := GetFileOutput{
+		Content:  content,
+		Size:     size,
+		Encoding: file.Encoding,
+		ModTime:  info.ModTime,
+		ETag:     etagForKey(file.StorageKey, file.Encoding),
+	}
+	if file.Encoding != "" && file.Encoding != domain.EncodingIdentity && !slices.Contains(accept, file.Encoding) {
+		out.Content = codec.NewSeekableReader(file.Encoding, content, file.Size)
+		out.Size = file.Size
+		out.Encoding = domain.EncodingIdentity
+		out.ETag = etagForKey(file.StorageKey, domain.EncodingIdentity)
+	}
+	return out, nil
+}
+
+// decrypt wraps encrypted content in a reader that decrypts it, returning the
+// plaintext size. It closes content on failure.
+func (s *Service) decrypt(dk domain.DataKey, content io.ReadSeekCloser, size int64) (io.ReadSeekCloser, int64, error) {
+	if s.cfg.Keys == nil {
+		_ = content.Close()
+		return nil, 0, errors.New("file is encrypted but no encryption keys are configured")
+	}
+	key, err := s.cfg.Keys.Unwrap(dk)
+	if err != nil {
+		_ = content.Close()
+		return nil, 0, fmt.Errorf("unwrap data key: %w", err)
+	}
+	plainSize, err := envelope.PlaintextSize(size)
+	if err != nil {
+		_ = content.Close()
+		return nil, 0, err
+	}
+	plain, err := envelope.NewReader(key, content, size)
+	if err != nil {
+		_ = content.Close()
+		return nil, 0, fmt.Errorf("open encrypted content: %w", err)
+	}
+	return plain, plainSize, nil
+}
+
+// repoError translates repository errors into usecase errors.
+func repoError(op string, err error) error {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, domain.ErrGone):
+		return ErrGone
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// etagForKey derives the entity tag of one representation of a blob. Encoded and
+// decoded bytes differ, so each encoding gets its own tag.
+func etagForKey(key string, encoding domain.ContentEncoding) string {
+	sum := sha256.Sum256([]byte(key))
+	tag := hex.EncodeToString(sum[:16])
+	if encoding != "" && encoding != domain.EncodingIdentity {
+		tag += "-" + string(encoding)
+	}
+	return `"` + tag + `"`
 }
@@ -1,24 +1,43 @@
 package usecase_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"async-file-storage/internal/domain"
+	"async-file-storage/internal/envelope"
+	"async-file-storage/internal/events"
+	"async-file-storage/internal/repository"
 	"async-file-storage/internal/usecase"
 )
 
 type mockRepo struct {
-	createRequestFunc func(ctx context.Context, urls []string) (int, error)
+	createRequestFunc func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error)
 	getRequestFunc    func(ctx context.Context, id int) error
-	getFileFunc       func(ctx context.Context, requestID int, fileID int) error
+	getFileFunc       func(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error)
+	deleteRequestFunc func(ctx context.Context, id int) ([]string, error)
+	listRequestsFunc  func(ctx context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error)
+	status            domain.Status
+	expiresAt         *time.Time
 }
 
-func (m *mockRepo) CreateRequest(ctx context.Context, urls []string) (int, error) {
-	return m.createRequestFunc(ctx, urls)
+func (m *mockRepo) CreateRequest(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+	return m.createRequestFunc(ctx, req)
+}
+
+func (m *mockRepo) GetRequest(ctx context.Context, id int) (*domain.DownloadRequest, error) {
+	if m.getRequestFunc != nil {
+		if err := m.getRequestFunc(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return &domain.DownloadRequest{ID: id, Status: m.status, ExpiresAt: m.expiresAt}, nil
 }
 
 func (m *mockRepo) GetRequestStatus(ctx context.Context, id int) (*domain.DownloadRequest, []domain.FileEntry, error) {
@@ -27,24 +46,108 @@ func (m *mockRepo) GetRequestStatus(ctx context.Context, id int) (*domain.Downlo
 			return nil, nil, err
 		}
 	}
-	return &domain.DownloadRequest{ID: id}, nil, nil
+	return &domain.DownloadRequest{ID: id, Status: m.status, ExpiresAt: m.expiresAt}, nil, nil
+}
+
+func (m *mockRepo) ListRequests(ctx context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error) {
+	return m.listRequestsFunc(ctx, query)
+}
+
+func (m *mockRepo) DeleteRequest(ctx context.Context, id int) ([]string, error) {
+	return m.deleteRequestFunc(ctx, id)
+}
+
+func (m *mockRepo) AppendFiles(ctx context.Context, requestID int, files domain.NewRequest, reopen bool) ([]domain.FileRef, error) {
+	return nil, domain.ErrFinished
+}
+
+func (m *mockRepo) ListEvents(ctx context.Context, requestID int, afterID int64, limit int) ([]domain.Event, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) ListProgress(ctx context.Context, requestID int) ([]domain.FileProgress, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) ListDeliveries(ctx context.Context, requestID int) ([]domain.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) RetryFiles(ctx context.Context, requestID int, fileIDs []int) ([]domain.FileRef, error) {
+	return nil, domain.ErrInProgress
 }
 
 func (m *mockRepo) GetFile(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
 	if m.getFileFunc != nil {
-		if err := m.getFileFunc(ctx, requestID, fileID); err != nil {
-			return nil, err
-		}
+		return m.getFileFunc(ctx, requestID, fileID)
 	}
 	return &domain.FileEntry{ID: fileID, RequestID: requestID}, nil
 }
 
 type mockDownloader struct {
-	startFunc func(ctx context.Context, requestID int, urls []string, timeout time.Duration) error
+	startFunc func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error
+	// keys records the idempotency key of every start.
+	keys []string
+	// retried records the files of every retry run.
+	retried [][]domain.FileRef
+	// added records the files of every addition.
+	added [][]domain.FileRef
+	// canceled records the request of every cancellation.
+	canceled  []int
+	cancelErr error
+}
+
+func (m *mockDownloader) StartDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	m.keys = append(m.keys, idempotencyKey)
+	return m.startFunc(ctx, requestID, files, timeout)
+}
+
+func (m *mockDownloader) RetryDownload(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	m.retried = append(m.retried, files)
+	return nil
+}
+
+func (m *mockDownloader) AddFiles(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration, idempotencyKey string) error {
+	m.added = append(m.added, files)
+	return nil
+}
+
+func (m *mockDownloader) CancelDownload(ctx context.Context, requestID int, idempotencyKey string) error {
+	m.canceled = append(m.canceled, requestID)
+	return m.cancelErr
+}
+
+type mockBlobStore struct {
+	blobs   map[string]string
+	deleted []string
+}
+
+type nopSeekCloser struct {
+	*strings.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func (m *mockBlobStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	data, ok := m.blobs[key]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return nopSeekCloser{strings.NewReader(data)}, nil
 }
 
-func (m *mockDownloader) StartDownload(ctx context.Context, requestID int, urls []string, timeout time.Duration) error {
-	return m.startFunc(ctx, requestID, urls, timeout)
+func (m *mockBlobStore) Delete(ctx context.Context, key string) error {
+	m.deleted = append(m.deleted, key)
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *mockBlobStore) Stat(ctx context.Context, key string) (domain.BlobInfo, error) {
+	data, ok := m.blobs[key]
+	if !ok {
+		return domain.BlobInfo{}, domain.ErrNotFound
+	}
+	return domain.BlobInfo{Key: key, Size: int64(len(data)), ModTime: time.Unix(1700000000, 0)}, nil
 }
 
 func TestServiceCreateRequest_Success(t *testing.T) {
@@ -52,20 +155,21 @@ func TestServiceCreateRequest_Success(t *testing.T) {
 	downloader := &mockDownloader{}
 
 	expectedURLs := []string{"https://example.com/a", "https://example.com/b"}
+	expectedFiles := []domain.FileRef{{ID: 7, URL: expectedURLs[0]}, {ID: 8, URL: expectedURLs[1]}}
 	expectedTimeout := 30 * time.Second
 
-	repo.createRequestFunc = func(ctx context.Context, urls []string) (int, error) {
-		if !reflect.DeepEqual(urls, expectedURLs) {
-			return 0, errors.New("unexpected urls")
+	repo.createRequestFunc = func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+		if !reflect.DeepEqual(req.URLs, expectedURLs) {
+			return 0, nil, errors.New("unexpected urls")
 		}
-		return 42, nil
+		return 42, expectedFiles, nil
 	}
-	downloader.startFunc = func(ctx context.Context, requestID int, urls []string, timeout time.Duration) error {
+	downloader.startFunc = func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
 		if requestID != 42 {
 			return errors.New("unexpected request id")
 		}
-		if !reflect.DeepEqual(urls, expectedURLs) {
-			return errors.New("unexpected urls")
+		if !reflect.DeepEqual(files, expectedFiles) {
+			return errors.New("unexpected files")
 		}
 		if timeout != expectedTimeout {
 			return errors.New("unexpected timeout")
@@ -73,7 +177,7 @@ func TestServiceCreateRequest_Success(t *testing.T) {
 		return nil
 	}
 
-	svc := usecase.NewService(repo, downloader)
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
 	out, err := svc.CreateRequest(context.Background(), usecase.CreateRequestInput{
 		URLs:    expectedURLs,
 		Timeout: expectedTimeout,
@@ -90,14 +194,14 @@ func TestServiceCreateRequest_Success(t *testing.T) {
 }
 
 func TestServiceCreateRequest_InvalidInput(t *testing.T) {
-	repo := &mockRepo{createRequestFunc: func(ctx context.Context, urls []string) (int, error) {
-		return 0, errors.New("should not be called")
+	repo := &mockRepo{createRequestFunc: func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+		return 0, nil, errors.New("should not be called")
 	}}
-	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, urls []string, timeout time.Duration) error {
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
 		return errors.New("should not be called")
 	}}
 
-	svc := usecase.NewService(repo, downloader)
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
 	_, err := svc.CreateRequest(context.Background(), usecase.CreateRequestInput{
 		URLs:    nil,
 		Timeout: 10 * time.Second,
@@ -106,3 +210,636 @@ func TestServiceCreateRequest_InvalidInput(t *testing.T) {
 		t.Fatalf("expected ErrInvalidInput, got %v", err)
 	}
 }
+
+func TestServiceGetFile_ReadsFromBlobStore(t *testing.T) {
+	repo := &mockRepo{getFileFunc: func(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
+		return &domain.FileEntry{ID: fileID, RequestID: requestID, StorageKey: "requests/1/a", Size: 5}, nil
+	}}
+	blobs := &mockBlobStore{blobs: map[string]string{"requests/1/a": "hello"}}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, blobs, usecase.Config{})
+	out, err := svc.GetFile(context.Background(), 1, 7, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer out.Content.Close()
+
+	data, err := io.ReadAll(out.Content)
+	if err != nil {
+		t.Fatalf("read content: %v", err)
+	}
+	if string(data) != "hello" || out.Size != 5 {
+		t.Fatalf("unexpected content %q (size %d)", data, out.Size)
+	}
+	if out.ETag == "" || out.ModTime.IsZero() {
+		t.Fatalf("expected validators, got etag %q modtime %v", out.ETag, out.ModTime)
+	}
+}
+
+func TestServiceGetFile_MissingBlob(t *testing.T) {
+	repo := &mockRepo{getFileFunc: func(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
+		return &domain.FileEntry{ID: fileID, RequestID: requestID, StorageKey: "requests/1/gone"}, nil
+	}}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, &mockBlobStore{}, usecase.Config{})
+	_, err := svc.GetFile(context.Background(), 1, 7, nil)
+	if !errors.Is(err, usecase.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestServiceGetFile_DecryptsContent(t *testing.T) {
+	keys, err := envelope.ParseKeyring([]byte(`{"active":"k1","keys":{"k1":"` + strings.Repeat("B", 43) + `="}}`))
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+	plainKey, dk, err := keys.NewDataKey()
+	if err != nil {
+		t.Fatalf("new data key: %v", err)
+	}
+	var sealed bytes.Buffer
+	w, err := envelope.NewWriter(plainKey, &sealed)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	_, _ = io.WriteString(w, "top secret")
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	repo := &mockRepo{getFileFunc: func(ctx context.Context, requestID int, fileID int) (*domain.FileEntry, error) {
+		return &domain.FileEntry{ID: fileID, RequestID: requestID, StorageKey: "requests/1/a", Size: 10, DataKey: dk}, nil
+	}}
+	blobs := &mockBlobStore{blobs: map[string]string{"requests/1/a": sealed.String()}}
+
+	if _, err := usecase.NewService(repo, &mockDownloader{}, blobs, usecase.Config{}).GetFile(context.Background(), 1, 7, nil); err == nil {
+		t.Fatal("expected an error without encryption keys")
+	}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, blobs, usecase.Config{Keys: keys})
+	out, err := svc.GetFile(context.Background(), 1, 7, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer out.Content.Close()
+	data, err := io.ReadAll(out.Content)
+	if err != nil || string(data) != "top secret" || out.Size != 10 {
+		t.Fatalf("unexpected content %q (size %d): %v", data, out.Size, err)
+	}
+}
+
+func TestServiceDeleteRequest_DeletesOrphanedBlobs(t *testing.T) {
+	repo := &mockRepo{status: domain.StatusDone, deleteRequestFunc: func(ctx context.Context, id int) ([]string, error) {
+		return []string{"content/a"}, nil
+	}}
+	blobs := &mockBlobStore{blobs: map[string]string{"content/a": "x", "content/shared": "y"}}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, blobs, usecase.Config{})
+	if err := svc.DeleteRequest(context.Background(), 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(blobs.deleted, []string{"content/a"}) {
+		t.Fatalf("expected only the orphaned blob to be deleted, got %v", blobs.deleted)
+	}
+}
+
+func TestServiceDeleteRequest_InProgress(t *testing.T) {
+	repo := &mockRepo{status: domain.StatusProcess, deleteRequestFunc: func(ctx context.Context, id int) ([]string, error) {
+		return nil, errors.New("should not be called")
+	}}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, &mockBlobStore{}, usecase.Config{})
+	if err := svc.DeleteRequest(context.Background(), 3); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestServiceCreateRequest_Retention(t *testing.T) {
+	var stored *time.Time
+	repo := &mockRepo{createRequestFunc: func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+		stored = req.ExpiresAt
+		return 1, nil, nil
+	}}
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{DefaultRetention: time.Hour, MaxRetention: 24 * time.Hour})
+	input := usecase.CreateRequestInput{URLs: []string{"https://example.com/a"}, Timeout: time.Second}
+
+	before := time.Now()
+	out, err := svc.CreateRequest(context.Background(), input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored == nil || out.ExpiresAt == nil || stored.Before(before.Add(time.Hour)) || stored.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected default retention of one hour, got %v", stored)
+	}
+
+	tooLate := time.Now().Add(48 * time.Hour)
+	input.ExpiresAt = &tooLate
+	if _, err := svc.CreateRequest(context.Background(), input); !errors.Is(err, usecase.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput past the maximum retention, got %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	input.ExpiresAt = &past
+	if _, err := svc.CreateRequest(context.Background(), input); !errors.Is(err, usecase.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput for a past expiry, got %v", err)
+	}
+}
+
+func TestServiceCreateRequest_DuplicateURLs(t *testing.T) {
+	var created []string
+	repo := &mockRepo{createRequestFunc: func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+		created = req.URLs
+		return 1, []domain.FileRef{{ID: 1, URL: req.URLs[0]}, {ID: 2, URL: req.URLs[1]}}, nil
+	}}
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	input := usecase.CreateRequestInput{URLs: []string{"https://example.com/a", "https://example.com/a"}, Timeout: time.Second}
+
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
+	if _, err := svc.CreateRequest(context.Background(), input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(created, input.URLs) {
+		t.Fatalf("expected both occurrences to be stored, got %v", created)
+	}
+
+	created = nil
+	svc = usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{RejectDuplicateURLs: true})
+	_, err := svc.CreateRequest(context.Background(), input)
+	if !errors.Is(err, usecase.ErrInvalidInput) || !strings.Contains(err.Error(), "duplicate url") {
+		t.Fatalf("expected a duplicate url error, got %v", err)
+	}
+	if created != nil {
+		t.Fatalf("expected nothing to be stored, got %v", created)
+	}
+}
+
+func TestServiceCreateRequest_CallbackURL(t *testing.T) {
+	var stored string
+	repo := &mockRepo{createRequestFunc: func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+		stored = req.CallbackURL
+		return 1, []domain.FileRef{{ID: 1, URL: req.URLs[0]}}, nil
+	}}
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	input := usecase.CreateRequestInput{
+		URLs: []string{"https://example.com/a"}, Timeout: time.Second, CallbackURL: "https://hooks.example.com/done",
+	}
+
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
+	if _, err := svc.CreateRequest(context.Background(), input); !errors.Is(err, usecase.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput while callbacks are disabled, got %v", err)
+	}
+
+	svc = usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{AllowCallbacks: true})
+	if _, err := svc.CreateRequest(context.Background(), input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored != input.CallbackURL {
+		t.Fatalf("expected the callback URL to be stored, got %q", stored)
+	}
+
+	for _, callback := range []string{"ftp://hooks.example.com/done", "/done", "https://", "https://hooks.example.com/" + strings.Repeat("a", 2048)} {
+		input.CallbackURL = callback
+		if _, err := svc.CreateRequest(context.Background(), input); !errors.Is(err, usecase.ErrInvalidInput) {
+			t.Fatalf("expected ErrInvalidInput for %q, got %v", callback, err)
+		}
+	}
+}
+
+func TestServiceCreateRequest_Checksums(t *testing.T) {
+	var got []domain.Digests
+	repo := &mockRepo{createRequestFunc: func(ctx context.Context, req domain.NewRequest) (int, []domain.FileRef, error) {
+		got = req.Checksums
+		return 1, nil, nil
+	}}
+	svc := usecase.NewService(repo, &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}, &mockBlobStore{}, usecase.Config{})
+	input := usecase.CreateRequestInput{URLs: []string{"https://example.com/a", "https://example.com/b"}, Timeout: time.Second}
+
+	input.Checksums = []domain.Digests{{}, {MD5: "D41D8CD98F00B204E9800998ECF8427E"}}
+	if _, err := svc.CreateRequest(context.Background(), input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[1].MD5 != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Fatalf("expected normalized checksums, got %+v", got)
+	}
+
+	for _, bad := range []domain.Digests{{SHA256: "abc"}, {SHA512: strings.Repeat("z", 128)}, {MD5: strings.Repeat("0", 64)}} {
+		input.Checksums = []domain.Digests{bad}
+		if _, err := svc.CreateRequest(context.Background(), input); !errors.Is(err, usecase.ErrInvalidInput) {
+			t.Fatalf("expected ErrInvalidInput for %+v, got %v", bad, err)
+		}
+	}
+}
+
+func TestServiceCreateRequest_IdempotencyKey(t *testing.T) {
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	svc := usecase.NewService(repository.NewMemoryRepository(), downloader, &mockBlobStore{},
+		usecase.Config{DefaultRetention: time.Hour})
+	input := usecase.CreateRequestInput{
+		URLs:           []string{"https://example.com/a"},
+		Timeout:        time.Minute,
+		IdempotencyKey: "retry-me",
+	}
+
+	first, err := svc.CreateRequest(context.Background(), input)
+	if err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	second, err := svc.CreateRequest(context.Background(), input)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected the retry to return %+v, got %+v", first, second)
+	}
+	// The workflow is started again under the same key; the downloader drops the duplicate.
+	if !reflect.DeepEqual(downloader.keys, []string{"retry-me", "retry-me"}) {
+		t.Fatalf("unexpected starts %v", downloader.keys)
+	}
+
+	input.Timeout = 2 * time.Minute
+	if _, err := svc.CreateRequest(context.Background(), input); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict for a different body, got %v", err)
+	}
+}
+
+func TestServiceGetRequest_Expired(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	repo := &mockRepo{status: domain.StatusDone, expiresAt: &expired}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, &mockBlobStore{}, usecase.Config{})
+	if _, err := svc.GetRequest(context.Background(), 1); !errors.Is(err, usecase.ErrGone) {
+		t.Fatalf("expected ErrGone, got %v", err)
+	}
+	if _, err := svc.GetFile(context.Background(), 1, 2, nil); !errors.Is(err, usecase.ErrGone) {
+		t.Fatalf("expected ErrGone, got %v", err)
+	}
+}
+
+func TestServiceGetRequest_RunningRequestDoesNotExpire(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	repo := &mockRepo{status: domain.StatusProcess, expiresAt: &expired}
+
+	svc := usecase.NewService(repo, &mockDownloader{}, &mockBlobStore{}, usecase.Config{})
+	out, err := svc.GetRequest(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("expected the running request, got %v", err)
+	}
+	if out.Status != domain.StatusProcess {
+		t.Fatalf("expected status PROCESS, got %s", out.Status)
+	}
+}
+
+func TestServiceListRequests_Pagination(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var queries []domain.ListRequestsQuery
+	repo := &mockRepo{listRequestsFunc: func(ctx context.Context, query domain.ListRequestsQuery) ([]domain.DownloadRequest, error) {
+		queries = append(queries, query)
+		if query.After != nil {
+			return []domain.DownloadRequest{{ID: 1, Status: domain.StatusDone, CreatedAt: created}}, nil
+		}
+		return []domain.DownloadRequest{
+			{ID: 3, Status: domain.StatusProcess, CreatedAt: created},
+			{ID: 2, Status: domain.StatusDone, CreatedAt: created},
+			{ID: 1, Status: domain.StatusDone, CreatedAt: created},
+		}, nil
+	}}
+	svc := usecase.NewService(repo, &mockDownloader{}, &mockBlobStore{}, usecase.Config{})
+
+	first, err := svc.ListRequests(context.Background(), usecase.ListRequestsInput{Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(first.Requests) != 2 || first.Requests[1].ID != 2 || first.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	if q := queries[0]; !q.Descending || q.Limit != 3 || q.Filter.ActiveAt == nil {
+		t.Fatalf("unexpected query %+v", q)
+	}
+
+	second, err := svc.ListRequests(context.Background(), usecase.ListRequestsInput{Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after := queries[1].After; after == nil || after.ID != 2 || !after.CreatedAt.Equal(created) {
+		t.Fatalf("expected the listing to continue after request 2, got %+v", after)
+	}
+	if len(second.Requests) != 1 || second.NextCursor != "" {
+		t.Fatalf("unexpected last page %+v", second)
+	}
+
+	_, err = svc.ListRequests(context.Background(), usecase.ListRequestsInput{Sort: usecase.SortCreatedAsc, Cursor: first.NextCursor})
+	if !errors.Is(err, usecase.ErrInvalidInput) {
+		t.Fatalf("expected a cursor from another sort to be rejected, got %v", err)
+	}
+}
+
+func TestServiceCancelRequest(t *testing.T) {
+	repo := &mockRepo{status: domain.StatusProcess}
+	downloader := &mockDownloader{}
+	service := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
+
+	if err := service.CancelRequest(context.Background(), 7); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if len(downloader.canceled) != 1 || downloader.canceled[0] != 7 {
+		t.Fatalf("expected the download of request 7 to be canceled, got %v", downloader.canceled)
+	}
+
+	// The workflow may finish between reading the status and canceling it.
+	downloader.cancelErr = domain.ErrNotFound
+	if err := service.CancelRequest(context.Background(), 7); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict for a finished workflow, got %v", err)
+	}
+
+	repo.status = domain.StatusDone
+	downloader.canceled = nil
+	if err := service.CancelRequest(context.Background(), 7); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict for a finished request, got %v", err)
+	}
+	if len(downloader.canceled) != 0 {
+		t.Fatalf("expected a finished request not to be canceled, got %v", downloader.canceled)
+	}
+}
+
+func TestServiceRetryRequest(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
+	ctx := context.Background()
+
+	created, err := svc.CreateRequest(ctx, usecase.CreateRequestInput{
+		URLs:    []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"},
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := svc.RetryRequest(ctx, usecase.RetryRequestInput{ID: created.ID}); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict while in progress, got %v", err)
+	}
+
+	// The first file succeeds, the others fail.
+	status, err := svc.GetRequest(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	ids := []int{status.Files[0].FileID, status.Files[1].FileID, status.Files[2].FileID}
+	for i, id := range ids {
+		if err := repo.UpdateFileStatus(ctx, created.ID, id, domain.FileDownloading, domain.FileMeta{}, nil); err != nil {
+			t.Fatalf("start file: %v", err)
+		}
+		if i == 0 {
+			_, _, err = repo.SaveFileContent(ctx, created.ID, id, domain.StoredContent{Hash: "h", StorageKey: "k"}, domain.FileMeta{})
+		} else {
+			err = repo.UpdateFileStatus(ctx, created.ID, id, domain.FileFailed, domain.FileMeta{HTTPStatus: 500}, errors.New("HTTP_500"))
+		}
+		if err != nil {
+			t.Fatalf("finish file: %v", err)
+		}
+	}
+	if err := repo.UpdateRequestStatus(ctx, created.ID, domain.StatusPartial); err != nil {
+		t.Fatalf("finish request: %v", err)
+	}
+
+	if _, err := svc.RetryRequest(ctx, usecase.RetryRequestInput{ID: created.ID, FileIDs: []int{ids[0]}}); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict for a downloaded file, got %v", err)
+	}
+	if _, err := svc.RetryRequest(ctx, usecase.RetryRequestInput{ID: created.ID, FileIDs: []int{999}}); !errors.Is(err, usecase.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown file, got %v", err)
+	}
+
+	out, err := svc.RetryRequest(ctx, usecase.RetryRequestInput{ID: created.ID})
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if out.Status != domain.StatusProcess || !reflect.DeepEqual(out.FileIDs, ids[1:]) {
+		t.Fatalf("expected a retry of %v, got %+v", ids[1:], out)
+	}
+	if len(downloader.retried) != 1 || len(downloader.retried[0]) != 2 {
+		t.Fatalf("expected one run for two files, got %v", downloader.retried)
+	}
+
+	status, err = svc.GetRequest(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if status.Status != domain.StatusProcess {
+		t.Fatalf("expected PROCESS, got %s", status.Status)
+	}
+	for i, f := range status.Files {
+		want := domain.FilePending
+		if i == 0 {
+			want = domain.FileSucceeded
+		}
+		if f.FileID != ids[i] || f.State != want || f.Attempts != 1 || (i > 0 && (f.ErrorCode != "" || f.HTTPStatus != 0)) {
+			t.Fatalf("unexpected file %d after the retry: %+v", i, f)
+		}
+	}
+}
+
+func TestServiceAddFiles(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{RejectDuplicateURLs: true})
+	ctx := context.Background()
+
+	created, err := svc.CreateRequest(ctx, usecase.CreateRequestInput{URLs: []string{"https://example.com/a"}, Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	input := usecase.AddFilesInput{ID: created.ID, URLs: []string{"https://example.com/a"}}
+	if _, err := svc.AddFiles(ctx, input); !errors.Is(err, usecase.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput for a url the request already has, got %v", err)
+	}
+
+	input.URLs = []string{"https://example.com/b"}
+	out, err := svc.AddFiles(ctx, input)
+	if err != nil {
+		t.Fatalf("add files: %v", err)
+	}
+	if len(out.Files) != 1 || out.Files[0].URL != "https://example.com/b" || out.Status != domain.StatusProcess {
+		t.Fatalf("unexpected output %+v", out)
+	}
+	if !reflect.DeepEqual(downloader.added, [][]domain.FileRef{out.Files}) {
+		t.Fatalf("expected the download to get %v, got %v", out.Files, downloader.added)
+	}
+
+	if err := repo.UpdateRequestStatus(ctx, created.ID, domain.StatusDone); err != nil {
+		t.Fatalf("finish request: %v", err)
+	}
+	input.URLs = []string{"https://example.com/c"}
+	if _, err := svc.AddFiles(ctx, input); !errors.Is(err, usecase.ErrConflict) {
+		t.Fatalf("expected ErrConflict for a finished request, got %v", err)
+	}
+	input.Reopen = true
+	if _, err := svc.AddFiles(ctx, input); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	status, err := svc.GetRequest(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if status.Status != domain.StatusProcess || len(status.Files) != 3 || status.Files[2].State != domain.FilePending {
+		t.Fatalf("expected a reopened request with three files, got %+v", status)
+	}
+}
+
+func TestServiceGetRequest_Progress(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	downloader := &mockDownloader{startFunc: func(ctx context.Context, requestID int, files []domain.FileRef, timeout time.Duration) error {
+		return nil
+	}}
+	svc := usecase.NewService(repo, downloader, &mockBlobStore{}, usecase.Config{})
+	ctx := context.Background()
+
+	created, err := svc.CreateRequest(ctx, usecase.CreateRequestInput{
+		URLs:    []string{"https://example.com/done", "https://example.com/running", "https://example.com/failed"},
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	status, err := svc.GetRequest(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	ids := []int{status.Files[0].FileID, status.Files[1].FileID, status.Files[2].FileID}
+	if _, _, err := repo.SaveFileContent(ctx, created.ID, ids[0], domain.StoredContent{Hash: "h", StorageKey: "k", Size: 1000}, domain.FileMeta{}); err != nil {
+		t.Fatalf("save content: %v", err)
+	}
+	started := time.Now().Add(-10 * time.Second)
+	if err := repo.UpdateFileStatus(ctx, created.ID, ids[1], domain.FileDownloading, domain.FileMeta{StartedAt: &started}, nil); err != nil {
+		t.Fatalf("start file: %v", err)
+	}
+	if err := repo.UpdateFileStatus(ctx, created.ID, ids[2], domain.FileFailed, domain.FileMeta{}, errors.New("HTTP_404")); err != nil {
+		t.Fatalf("fail file: %v", err)
+	}
+
+	// A quarter of the running file arrived in ten seconds: thirty more to go.
+	if err := repo.RecordProgress(ctx, created.ID, []domain.FileProgress{{FileID: ids[1], BytesDownloaded: 2500, BytesTotal: 10000}}); err != nil {
+		t.Fatalf("record progress: %v", err)
+	}
+	status, err = svc.GetRequest(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	running := status.Files[1].Progress
+	if running == nil || running.BytesDownloaded != 2500 || running.BytesTotal != 10000 ||
+		running.ETA < 29*time.Second || running.ETA > 31*time.Second {
+		t.Fatalf("unexpected progress of the running file %+v", running)
+	}
+	if done := status.Files[0].Progress; done == nil || done.BytesDownloaded != 1000 || done.BytesTotal != 1000 {
+		t.Fatalf("unexpected progress of the downloaded file %+v", done)
+	}
+	if status.Files[2].Progress != nil {
+		t.Fatalf("expected no progress for the failed file, got %+v", status.Files[2].Progress)
+	}
+	if p := status.Progress; p.BytesDownloaded != 3500 || p.BytesTotal != 11000 || p.ETA != running.ETA {
+		t.Fatalf("unexpected request progress %+v", p)
+	}
+
+	// Without a Content-Length the total and the ETA are unknown.
+	if err := repo.RecordProgress(ctx, created.ID, []domain.FileProgress{{FileID: ids[1], BytesDownloaded: 2500}}); err != nil {
+		t.Fatalf("record progress: %v", err)
+	}
+	status, err = svc.GetRequest(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if p := status.Progress; p.BytesDownloaded != 3500 || p.BytesTotal != 0 || p.ETA != 0 {
+		t.Fatalf("expected an unknown total, got %+v", p)
+	}
+}
+
+func TestServiceEventStream(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	hub := events.NewHub()
+	svc := usecase.NewService(repo, &mockDownloader{}, &mockBlobStore{}, usecase.Config{Events: hub})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, refs, err := repo.CreateRequest(ctx, domain.NewRequest{URLs: []string{"https://example.com/a"}, Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := repo.UpdateFileStatus(ctx, id, refs[0].ID, domain.FileDownloading, domain.FileMeta{}, nil); err != nil {
+		t.Fatalf("start file: %v", err)
+	}
+	if _, err := svc.OpenEventStream(ctx, id+1, 0); !errors.Is(err, usecase.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown request, got %v", err)
+	}
+	stream, err := svc.OpenEventStream(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+
+	received := make(chan []domain.Event, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Stream(ctx, func(events []domain.Event) error {
+			received <- events
+			return nil
+		})
+	}()
+	next := func() []domain.Event {
+		t.Helper()
+		select {
+		case events := <-received:
+			return events
+		case <-time.After(5 * time.Second):
+			t.Fatal("no events streamed")
+			return nil
+		}
+	}
+
+	first := next()
+	if len(first) != 1 || first[0].Type != domain.EventFileStarted {
+		t.Fatalf("expected the recorded event first, got %+v", first)
+	}
+	// The stream waits for the notifier, not for its keep-alive.
+	if err := repo.UpdateFileStatus(ctx, id, refs[0].ID, domain.FileFailed, domain.FileMeta{}, errors.New("HTTP_500")); err != nil {
+		t.Fatalf("fail file: %v", err)
+	}
+	hub.Notify(id)
+	if got := next(); len(got) != 1 || got[0].Type != domain.EventFileFailed || got[0].ID <= first[0].ID {
+		t.Fatalf("expected the failure next, got %+v", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expected the stream to end quietly, got %v", err)
+	}
+
+	// A reconnecting client continues behind the last event it saw.
+	resumed, err := svc.OpenEventStream(context.Background(), id, first[0].ID)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	resumeCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	var got []domain.Event
+	_ = resumed.Stream(resumeCtx, func(events []domain.Event) error {
+		got = events
+		stop()
+		return nil
+	})
+	if len(got) != 1 || got[0].Type != domain.EventFileFailed {
+		t.Fatalf("expected to resume at the failure, got %+v", got)
+	}
+}
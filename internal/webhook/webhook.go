@@ -0,0 +1,96 @@
+// Package webhook tells the callback URL of a request that the request finished.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"async-file-storage/internal/domain"
+	"async-file-storage/internal/statusview"
+	"async-file-storage/internal/usecase"
+)
+
+const (
+	// TimestampHeader carries the Unix time the notification was signed at, in seconds.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot
+	// and the body, keyed with the webhook secret.
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// sendTimeout bounds one delivery attempt, from connecting to reading the response.
+const sendTimeout = 30 * time.Second
+
+// Sign returns the SignatureHeader value of a body sent at timestamp.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// StatusError reports a response outside 2xx.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("callback answered %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Retryable reports whether the callback may accept the notification later: server
+// errors, timeouts and rate limits are retried, other client errors are not.
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Sender posts the status of finished requests to their callback URLs.
+type Sender struct {
+	secret []byte
+	client *http.Client
+}
+
+func NewSender(secret string) *Sender {
+	return &Sender{secret: []byte(secret), client: &http.Client{Timeout: sendTimeout}}
+}
+
+// Send posts the status of a request to its callback URL, as GET /downloads/{id} returns
+// it, and returns the HTTP status of the response; zero if none arrived. A response
+// outside 2xx yields a *StatusError.
+func (s *Sender) Send(ctx context.Context, req *domain.DownloadRequest, files []domain.FileEntry) (int, error) {
+	body, err := statusview.Marshal(usecase.RequestStatus(req, files))
+	if err != nil {
+		return 0, fmt.Errorf("encode status: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	httpReq.Header.Set(SignatureHeader, Sign(s.secret, timestamp, body))
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// Draining the body lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &StatusError{StatusCode: resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}